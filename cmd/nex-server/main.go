@@ -5,6 +5,8 @@ import (
 	"log"
 	"nex-server/internal/api"
 	"nex-server/internal/config"
+	"nex-server/internal/mqtt"
+	"nex-server/internal/sftpd"
 	"nex-server/internal/ws"
 
 	"github.com/gin-gonic/gin"
@@ -19,13 +21,15 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Logger(), api.Recovery())
 
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", c.Request.Header.Get("Origin"))
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 		c.Writer.Header().Set("Access-Control-Max-Age", "43200")
 		c.Writer.Header().Set("Access-Control-Allow-Private-Network", "true")
 
@@ -41,6 +45,23 @@ func main() {
 
 	go wsManager.Run()
 
+	if !config.Current.System.SFTP.Disable {
+		sftpServer, err := sftpd.NewServer(wsManager.BroadcastEvent)
+		if err != nil {
+			log.Printf("SFTP server disabled: %v", err)
+		} else {
+			go func() {
+				if err := sftpServer.ListenAndServe(); err != nil {
+					log.Printf("SFTP server stopped: %v", err)
+				}
+			}()
+		}
+	}
+
+	if config.Current.MQTT.Enable {
+		go mqtt.NewBridge(wsManager).Run()
+	}
+
 	api.SetupRoutes(r, wsManager)
 
 	addr := fmt.Sprintf("%s:%d", config.Current.API.Host, config.Current.API.Port)
@@ -0,0 +1,227 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const recentLimit = 50
+
+var notificationUrgency = map[string]string{
+	"info":     "low",
+	"warning":  "normal",
+	"critical": "critical",
+}
+
+// Engine evaluates the alert rules of the config against every stats
+// sample. A rule fires after its condition held for the whole duration and
+// resolves once the value moved back past the threshold by the hysteresis,
+// so a value hovering around the threshold does not flap.
+type Engine struct {
+	emit   func(event string, payload interface{})
+	notify func(summary, body, urgency string) error
+
+	// invalid holds the rules with an unknown metric or comparison, they
+	// are logged once and never evaluated.
+	invalid map[int]bool
+
+	mu      sync.Mutex
+	pending map[int]time.Time
+	active  map[int]*models.Alert
+	recent  []models.Alert
+}
+
+func NewEngine(emit func(event string, payload interface{}), notify func(summary, body, urgency string) error) *Engine {
+	e := &Engine{
+		emit:    emit,
+		notify:  notify,
+		invalid: make(map[int]bool),
+		pending: make(map[int]time.Time),
+		active:  make(map[int]*models.Alert),
+	}
+	for i, rule := range config.Current.Alerts {
+		if err := checkRule(rule); err != nil {
+			log.Printf("Alert rule %q ignored: %v", rule.Name, err)
+			e.invalid[i] = true
+		}
+	}
+	return e
+}
+
+func checkRule(rule config.AlertRule) error {
+	if _, ok := metrics[rule.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q", rule.Metric)
+	}
+	switch rule.Comparison {
+	case ">", ">=", "<", "<=":
+	default:
+		return errors.New("comparison has to be one of >, >=, < and <=")
+	}
+	return nil
+}
+
+func (e *Engine) Evaluate(stats models.SystemStats) {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, rule := range config.Current.Alerts {
+		if e.invalid[i] {
+			continue
+		}
+		value, ok := metrics[rule.Metric](stats)
+		if !ok {
+			continue
+		}
+
+		if alert, firing := e.active[i]; firing {
+			alert.Value = value
+			if recovered(rule, value) {
+				e.resolve(i, now)
+			}
+			continue
+		}
+
+		if !breached(rule, value) {
+			delete(e.pending, i)
+			continue
+		}
+		since, ok := e.pending[i]
+		if !ok {
+			since = now
+			e.pending[i] = since
+		}
+		if now.Sub(since) >= time.Duration(rule.Duration)*time.Second {
+			delete(e.pending, i)
+			e.fire(i, rule, value, since, now)
+		}
+	}
+}
+
+func (e *Engine) fire(index int, rule config.AlertRule, value float64, since, now time.Time) {
+	severity := rule.Severity
+	if severity == "" {
+		severity = "warning"
+	}
+
+	alert := &models.Alert{
+		ID:         uuid.New().String(),
+		Name:       rule.Name,
+		Metric:     rule.Metric,
+		Comparison: rule.Comparison,
+		Threshold:  rule.Threshold,
+		Value:      value,
+		Severity:   severity,
+		State:      "firing",
+		StartedAt:  since.Unix(),
+		FiredAt:    now.Unix(),
+	}
+	e.active[index] = alert
+	e.emit("alert", *alert)
+
+	if rule.Notify && e.notify != nil {
+		urgency := notificationUrgency[severity]
+		if urgency == "" {
+			urgency = "normal"
+		}
+		body := fmt.Sprintf("%s is %.1f (%s %g)", rule.Metric, value, rule.Comparison, rule.Threshold)
+		go e.notify(rule.Name, body, urgency)
+	}
+}
+
+func (e *Engine) resolve(index int, now time.Time) {
+	alert := e.active[index]
+	delete(e.active, index)
+
+	alert.State = "resolved"
+	alert.ResolvedAt = now.Unix()
+	e.recent = append([]models.Alert{*alert}, e.recent...)
+	if len(e.recent) > recentLimit {
+		e.recent = e.recent[:recentLimit]
+	}
+	e.emit("alert-resolved", *alert)
+}
+
+// List returns the firing alerts and the most recently resolved ones,
+// newest first.
+func (e *Engine) List() models.AlertList {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	list := models.AlertList{Active: []models.Alert{}, Recent: append([]models.Alert{}, e.recent...)}
+	for i := range config.Current.Alerts {
+		if alert, ok := e.active[i]; ok {
+			list.Active = append(list.Active, *alert)
+		}
+	}
+	return list
+}
+
+func breached(rule config.AlertRule, value float64) bool {
+	switch rule.Comparison {
+	case ">":
+		return value > rule.Threshold
+	case ">=":
+		return value >= rule.Threshold
+	case "<":
+		return value < rule.Threshold
+	case "<=":
+		return value <= rule.Threshold
+	}
+	return false
+}
+
+func recovered(rule config.AlertRule, value float64) bool {
+	switch rule.Comparison {
+	case ">", ">=":
+		return value < rule.Threshold-rule.Hysteresis
+	case "<", "<=":
+		return value > rule.Threshold+rule.Hysteresis
+	}
+	return true
+}
+
+// metrics maps the rule metrics onto the stats. Sizes are compared as
+// percentages, which is what a threshold like "95% full" means. The value
+// is unavailable when the stats do not have it (no battery, no Wi-Fi).
+var metrics = map[string]func(stats models.SystemStats) (float64, bool){
+	"cpu": func(stats models.SystemStats) (float64, bool) {
+		return stats.CpuAbsolute, true
+	},
+	"cpu_temp": func(stats models.SystemStats) (float64, bool) {
+		return stats.CpuTemp, stats.CpuTemp > 0
+	},
+	"memory_percent": func(stats models.SystemStats) (float64, bool) {
+		return percent(stats.MemoryBytes, stats.MemoryLimitBytes)
+	},
+	"swap_percent": func(stats models.SystemStats) (float64, bool) {
+		return percent(stats.SwapBytes, stats.SwapLimitBytes)
+	},
+	"disk_percent": func(stats models.SystemStats) (float64, bool) {
+		return percent(stats.DiskBytes, stats.DiskTotal)
+	},
+	"battery": func(stats models.SystemStats) (float64, bool) {
+		return float64(stats.Battery.Percentage), stats.Battery.Present
+	},
+	"volume": func(stats models.SystemStats) (float64, bool) {
+		return float64(stats.Volume), true
+	},
+	"wifi_strength": func(stats models.SystemStats) (float64, bool) {
+		return float64(stats.Wifi.Strength), stats.Wifi.Connected
+	},
+}
+
+func percent(used, total uint64) (float64, bool) {
+	if total == 0 {
+		return 0, false
+	}
+	return float64(used) / float64(total) * 100, true
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var playerActions = map[string]bool{
+	"play": true, "pause": true, "play-pause": true, "next": true, "previous": true, "stop": true,
+}
+
+// activeActions control the player chosen by the server, like the media
+// event of the websocket.
+var activeActions = map[string]string{
+	"play-pause": "play_pause", "next": "next", "previous": "previous",
+}
+
+// execute runs an action for the caller, answering the error when it
+// fails.
+func execute(c *gin.Context, wsManager *ws.Manager, event string, args ...string) bool {
+	claims := c.MustGet("claims").(*auth.Claims)
+	if err := wsManager.Execute(claims, event, args); err != nil {
+		actionError(c, err)
+		return false
+	}
+	return true
+}
+
+// setupActionRoutes exposes the websocket actions over REST. Every route
+// goes through Manager.Execute, so permissions and read only mode are the
+// same as on the socket.
+func setupActionRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	v1 := r.Group("/v1", requireAuth(""))
+
+	v1.POST("/media/:player/:action", func(c *gin.Context) {
+		player, action := c.Param("player"), c.Param("action")
+
+		switch {
+		case player == "active" && activeActions[action] != "":
+			if !execute(c, wsManager, "media", activeActions[action]) {
+				return
+			}
+		case player != "active" && playerActions[action]:
+			if !execute(c, wsManager, "audio-"+action, player) {
+				return
+			}
+		case player != "active" && action == "seek":
+			var req models.SeekRequest
+			if !bindJSON(c, &req) {
+				return
+			}
+			if !execute(c, wsManager, "audio-position", player, strconv.FormatInt(req.Position, 10)) {
+				return
+			}
+		default:
+			abortError(c, http.StatusNotFound, models.ErrNotFound, "unknown action", nil)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	v1.POST("/audio/volume", func(c *gin.Context) {
+		var req models.VolumeRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		ok := false
+		switch {
+		case req.Volume != nil:
+			ok = execute(c, wsManager, "volume-set", strconv.Itoa(*req.Volume))
+		case req.Step != nil:
+			ok = execute(c, wsManager, "volume-step", strconv.Itoa(*req.Step))
+		default:
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "volume or step is required", nil)
+		}
+		if ok {
+			c.Status(http.StatusNoContent)
+		}
+	})
+
+	for path, event := range map[string]string{"/audio/mute": "volume-mute", "/audio/mic-mute": "mic-mute"} {
+		v1.POST(path, func(c *gin.Context) {
+			req := models.MuteRequest{Mode: "toggle"}
+			if c.Request.ContentLength > 0 {
+				if !bindJSON(c, &req) {
+					return
+				}
+			}
+			if execute(c, wsManager, event, req.Mode) {
+				c.Status(http.StatusNoContent)
+			}
+		})
+	}
+
+	for path, event := range map[string]string{"/audio/default-sink": "sound-default-sink", "/audio/default-source": "sound-default-source"} {
+		v1.POST(path, func(c *gin.Context) {
+			var req models.DeviceRequest
+			if !bindJSON(c, &req) {
+				return
+			}
+			if execute(c, wsManager, event, req.Name) {
+				c.Status(http.StatusNoContent)
+			}
+		})
+	}
+
+	// Applies the given fields in the order volume, mute, sink.
+	v1.POST("/audio/streams/:index", func(c *gin.Context) {
+		var req models.StreamRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		index := c.Param("index")
+		if req.Volume != nil && !execute(c, wsManager, "stream-volume", index, strconv.Itoa(*req.Volume)) {
+			return
+		}
+		if req.Mute != "" && !execute(c, wsManager, "stream-mute", index, req.Mute) {
+			return
+		}
+		if req.Sink != "" && !execute(c, wsManager, "stream-move", index, req.Sink) {
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	v1.POST("/brightness/:device", func(c *gin.Context) {
+		var req models.BrightnessRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		if req.Percent == nil {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "percent is required", nil)
+			return
+		}
+		if execute(c, wsManager, "set-brightness", c.Param("device"), strconv.Itoa(*req.Percent)) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+
+	// Any action of the websocket by its event name, with the same
+	// arguments, for everything without a dedicated route.
+	v1.POST("/actions/:event", func(c *gin.Context) {
+		var req models.ActionRequest
+		if c.Request.ContentLength > 0 {
+			if !bindJSON(c, &req) {
+				return
+			}
+		}
+		if execute(c, wsManager, c.Param("event"), req.Args...) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+}
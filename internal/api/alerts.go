@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/ws"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupAlertRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	r.GET("/v1/alerts", requireAuth(""), func(c *gin.Context) {
+		c.JSON(http.StatusOK, wsManager.Alerts.List())
+	})
+}
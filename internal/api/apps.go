@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/models"
+	"nex-server/internal/system"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupAppRoutes(r *gin.Engine) {
+	r.GET("/v1/apps", requireAuth(""), func(c *gin.Context) {
+		c.JSON(http.StatusOK, system.GetApplications())
+	})
+
+	r.POST("/v1/apps/:id/launch", requireAuth(""), rejectReadOnly, func(c *gin.Context) {
+		if err := system.LaunchApplication(c.Param("id")); err != nil {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, err.Error(), nil)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.GET("/v1/windows", requireAuth(""), func(c *gin.Context) {
+		windows, err := system.GetWindows()
+		if err != nil {
+			abortError(c, http.StatusNotImplemented, models.ErrNotImplemented, err.Error(), nil)
+			return
+		}
+		c.JSON(http.StatusOK, windows)
+	})
+}
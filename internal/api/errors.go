@@ -0,0 +1,84 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the ids taken over from clients or proxies, so they
+// are safe to log and echo.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID gives every request an id, the one sent by the client when it
+// is sane. It is returned in the X-Request-ID header and in error bodies.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = uuid.New().String()
+	}
+	c.Set("request_id", id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// abortError ends the request with the shared error body. Server errors are
+// logged with the request id, so a report from a client can be matched to
+// the log.
+func abortError(c *gin.Context, status int, code, message string, details gin.H) {
+	apiErr := models.APIError{
+		Code:      code,
+		Message:   message,
+		RequestID: c.GetString("request_id"),
+		Details:   details,
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s failed [%s]: %s", c.Request.Method, c.Request.URL.Path, apiErr.RequestID, message)
+	}
+	c.AbortWithStatusJSON(status, models.ErrorResponse{Error: apiErr})
+}
+
+// Recovery answers a panicking handler with internal_error instead of an
+// empty 500. gin logs the panic, the client only gets the request id.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+		abortError(c, http.StatusInternalServerError, models.ErrInternal, "internal server error", nil)
+	})
+}
+
+// bindJSON binds the request body, answering invalid_request when it does
+// not decode or misses a required field.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "invalid request body: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// actionError answers a failed ws.Manager.Execute with the status of its
+// error code.
+func actionError(c *gin.Context, err error) {
+	code := ws.ErrorCode(err)
+	status := http.StatusInternalServerError
+	switch code {
+	case models.ErrUnknownEvent, models.ErrNotFound:
+		status = http.StatusNotFound
+	case models.ErrForbidden, models.ErrReadOnly:
+		status = http.StatusForbidden
+	case models.ErrInvalidArguments:
+		status = http.StatusBadRequest
+	}
+	abortError(c, status, code, err.Error(), nil)
+}
+
+func notFound(c *gin.Context) {
+	abortError(c, http.StatusNotFound, models.ErrNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path, nil)
+}
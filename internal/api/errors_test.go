@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nex-server/internal/models"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Recovery(), requestID)
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set(requestIDHeader, "test-1")
+	w := serve(r, req)
+
+	var envelope models.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("body is not an error envelope: %q", w.Body)
+	}
+	if w.Code != http.StatusInternalServerError || envelope.Error.Code != models.ErrInternal || envelope.Error.RequestID != "test-1" {
+		t.Errorf("got %d %+v", w.Code, envelope.Error)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestID)
+	r.NoRoute(notFound)
+
+	for header, keep := range map[string]bool{"": false, "abc-1.2_3": true, "bad id": false} {
+		req := httptest.NewRequest("GET", "/missing", nil)
+		if header != "" {
+			req.Header.Set(requestIDHeader, header)
+		}
+		w := serve(r, req)
+
+		var envelope models.ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &envelope)
+		id := w.Header().Get(requestIDHeader)
+		if id == "" || envelope.Error.RequestID != id || (id == header) != keep {
+			t.Errorf("X-Request-ID %q: got header %q and body %+v", header, id, envelope.Error)
+		}
+		if w.Code != http.StatusNotFound || envelope.Error.Code != models.ErrNotFound {
+			t.Errorf("unknown route: got %d %+v", w.Code, envelope.Error)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"nex-server/internal/system"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupFileRoutes(r *gin.Engine) {
+	files := r.Group("/v1/files", requireAuth(auth.ScopeFiles))
+
+	files.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, system.FileRoots())
+	})
+
+	files.GET("/:root/*path", func(c *gin.Context) {
+		if c.Query("download") != "true" {
+			entry, err := system.StatPath(c.Param("root"), c.Param("path"))
+			if err != nil {
+				fileError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, entry)
+			return
+		}
+
+		if config.Current.API.DisableRemoteDownload {
+			abortError(c, http.StatusForbidden, models.ErrForbidden, "remote download is disabled", nil)
+			return
+		}
+		path, err := system.ResolvePath(c.Param("root"), c.Param("path"))
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		if !info.Mode().IsRegular() {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "not a file", nil)
+			return
+		}
+		c.FileAttachment(path, filepath.Base(path))
+	})
+
+	files.PUT("/:root/*path", rejectReadOnly, func(c *gin.Context) {
+		offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+		if err != nil || offset < 0 {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "invalid offset", nil)
+			return
+		}
+
+		limit := system.UploadLimit()
+		if c.Request.ContentLength > limit {
+			abortError(c, http.StatusRequestEntityTooLarge, models.ErrPayloadTooLarge, "chunk larger than upload limit", gin.H{"limit": limit})
+			return
+		}
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+		status, err := system.WriteChunk(c.Param("root"), c.Param("path"), offset, body, c.Query("complete") == "true")
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, system.ErrOffsetInvalid):
+			abortError(c, http.StatusConflict, models.ErrOffsetMismatch, err.Error(), gin.H{"offset": status.Offset})
+		case errors.As(err, &tooLarge):
+			abortError(c, http.StatusRequestEntityTooLarge, models.ErrPayloadTooLarge, "chunk larger than upload limit", gin.H{"limit": limit, "offset": status.Offset})
+		case err != nil:
+			fileError(c, err)
+		default:
+			c.JSON(http.StatusOK, status)
+		}
+	})
+
+	files.POST("/:root/*path", rejectReadOnly, func(c *gin.Context) {
+		if err := system.MakeDirectory(c.Param("root"), c.Param("path")); err != nil {
+			fileError(c, err)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	files.PATCH("/:root/*path", rejectReadOnly, func(c *gin.Context) {
+		var req models.FileRename
+		if !bindJSON(c, &req) {
+			return
+		}
+		if req.To == "" {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "to is required", nil)
+			return
+		}
+		if err := system.RenamePath(c.Param("root"), c.Param("path"), req.To); err != nil {
+			fileError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	files.DELETE("/:root/*path", rejectReadOnly, func(c *gin.Context) {
+		if err := system.DeletePath(c.Param("root"), c.Param("path"), c.Query("recursive") == "true"); err != nil {
+			fileError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func fileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, system.ErrUnknownRoot), os.IsNotExist(err):
+		abortError(c, http.StatusNotFound, models.ErrNotFound, "not found", nil)
+	case errors.Is(err, system.ErrOutsideRoot), errors.Is(err, system.ErrRootPath), os.IsPermission(err):
+		abortError(c, http.StatusForbidden, models.ErrForbidden, err.Error(), nil)
+	case errors.Is(err, system.ErrNotRegular):
+		abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, err.Error(), nil)
+	case os.IsExist(err), errors.Is(err, os.ErrExist):
+		abortError(c, http.StatusConflict, models.ErrConflict, "already exists", nil)
+	default:
+		abortError(c, http.StatusInternalServerError, models.ErrInternal, err.Error(), nil)
+	}
+}
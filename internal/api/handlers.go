@@ -8,8 +8,8 @@ import (
 	"nex-server/internal/auth"
 	"nex-server/internal/config"
 	"nex-server/internal/models"
+	"nex-server/internal/system"
 	"nex-server/internal/ws"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -26,21 +26,23 @@ func getOutboundIP() string {
 }
 
 func SetupRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	r.Use(requestID)
+	r.NoRoute(notFound)
+
 	r.POST("/v1/login", func(c *gin.Context) {
 		var login models.LoginRequest
-		if err := c.BindJSON(&login); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		if !bindJSON(c, &login) {
 			return
 		}
 
 		if login.Username != config.Current.User.Username || login.Password != config.Current.User.Password {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			abortError(c, http.StatusUnauthorized, models.ErrInvalidCredentials, "invalid credentials", nil)
 			return
 		}
 
-		token, err := auth.GenerateLoginToken(login.Username)
+		token, err := auth.GenerateLoginToken(login.Username, config.Current.User.Scopes)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "token gen failed"})
+			abortError(c, http.StatusInternalServerError, models.ErrInternal, "token generation failed: "+err.Error(), nil)
 			return
 		}
 
@@ -51,34 +53,30 @@ func SetupRoutes(r *gin.Engine, wsManager *ws.Manager) {
 		encodedPath := c.Param("encodedPath")
 		decodedBytes, err := base64.URLEncoding.DecodeString(encodedPath)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid path"})
-			return
-		}
-		c.File(string(decodedBytes))
-	})
-
-	r.GET("/v1/websocket", func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing auth header"})
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "invalid path", nil)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid auth header"})
+		// Only cover art currently advertised by a player, never an
+		// arbitrary path picked by the client.
+		path := string(decodedBytes)
+		if !system.IsPublishedArt(path) {
+			abortError(c, http.StatusNotFound, models.ErrNotFound, "not found", nil)
 			return
 		}
+		c.File(path)
+	})
 
-		claims, err := auth.ValidateToken(parts[1])
-		if err != nil || claims.Type != "login" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+	r.GET("/v1/websocket", func(c *gin.Context) {
+		claims, reason := bearerClaims(c)
+		if claims == nil {
+			abortError(c, http.StatusUnauthorized, models.ErrUnauthorized, reason, nil)
 			return
 		}
 
-		wsToken, err := auth.GenerateWSToken(claims.Username)
+		wsToken, err := auth.GenerateWSToken(claims.Username, claims.Scopes)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "ws token gen failed"})
+			abortError(c, http.StatusInternalServerError, models.ErrInternal, "websocket token generation failed: "+err.Error(), nil)
 			return
 		}
 
@@ -101,4 +99,17 @@ func SetupRoutes(r *gin.Engine, wsManager *ws.Manager) {
 	r.GET("/v1/monitor/:uuid/ws", func(c *gin.Context) {
 		ws.ServeWS(wsManager, c)
 	})
+
+	setupPowerRoutes(r, wsManager)
+	setupScreenshotRoutes(r)
+	setupAppRoutes(r)
+	setupFileRoutes(r)
+	setupHistoryRoutes(r, wsManager)
+	setupMetricsRoutes(r, wsManager)
+	setupAlertRoutes(r, wsManager)
+	setupWebhookRoutes(r, wsManager)
+	setupStatsRoutes(r, wsManager)
+	setupActionRoutes(r, wsManager)
+
+	setupSpecRoutes(r)
 }
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"nex-server/internal/history"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupHistoryRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	r.GET("/v1/history", requireAuth(""), func(c *gin.Context) {
+		if wsManager.History == nil {
+			abortError(c, http.StatusServiceUnavailable, models.ErrUnavailable, "history is disabled", nil)
+			return
+		}
+
+		var req models.HistoryRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "invalid query: "+err.Error(), nil)
+			return
+		}
+
+		result, err := wsManager.History.Query(req.Metric, req.From, req.To, req.Step)
+		if errors.Is(err, history.ErrUnknownMetric) {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, err.Error(), gin.H{"metrics": history.Metrics})
+			return
+		}
+		if err != nil {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, err.Error(), nil)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
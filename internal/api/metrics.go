@@ -0,0 +1,208 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupMetricsRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	if !config.Current.Metrics.Enable {
+		return
+	}
+
+	r.GET("/metrics", metricsAuth, func(c *gin.Context) {
+		w := newMetricWriter()
+		writeStatsMetrics(w, wsManager)
+		writeServerMetrics(w, wsManager)
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(w.String()))
+	})
+}
+
+// metricsAuth accepts the configured bearer token or a remote address from
+// the allowlist. The socket address is used, X-Forwarded-For is ignored.
+func metricsAuth(c *gin.Context) {
+	cfg := config.Current.Metrics
+
+	if cfg.Token != "" {
+		header := c.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+cfg.Token)) == 1 {
+			c.Next()
+			return
+		}
+	}
+
+	ip := net.ParseIP(c.RemoteIP())
+	if ip != nil {
+		if cfg.Token == "" && len(cfg.AllowedIPs) == 0 && ip.IsLoopback() {
+			c.Next()
+			return
+		}
+		for _, allowed := range cfg.AllowedIPs {
+			if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(ip) {
+				c.Next()
+				return
+			}
+			if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+				c.Next()
+				return
+			}
+		}
+	}
+
+	abortError(c, http.StatusUnauthorized, models.ErrUnauthorized, "unauthorized", nil)
+}
+
+func writeStatsMetrics(w *metricWriter, wsManager *ws.Manager) {
+	stats, ok := wsManager.Snapshot()
+	if !ok {
+		return
+	}
+
+	w.gauge("nex_memory_used_bytes", "Used memory.", float64(stats.MemoryBytes))
+	w.gauge("nex_memory_total_bytes", "Total memory.", float64(stats.MemoryLimitBytes))
+	w.gauge("nex_swap_used_bytes", "Used swap.", float64(stats.SwapBytes))
+	w.gauge("nex_swap_total_bytes", "Total swap.", float64(stats.SwapLimitBytes))
+	w.gauge("nex_cpu_usage_percent", "CPU usage over all cores.", stats.CpuAbsolute)
+	w.gauge("nex_cpu_temperature_celsius", "Primary CPU temperature.", stats.CpuTemp)
+	w.gauge("nex_uptime_seconds", "System uptime.", float64(stats.Uptime))
+	w.gauge("nex_disk_used_bytes", "Used space on the root filesystem.", float64(stats.DiskBytes), "mountpoint", "/")
+	w.gauge("nex_disk_total_bytes", "Size of the root filesystem.", float64(stats.DiskTotal), "mountpoint", "/")
+	w.counter("nex_network_receive_bytes_total", "Bytes received on all interfaces.", float64(stats.Network.RxBytes))
+	w.counter("nex_network_transmit_bytes_total", "Bytes sent on all interfaces.", float64(stats.Network.TxBytes))
+
+	// Samples of a metric have to be contiguous, hence one loop per metric.
+	for _, chip := range stats.Sensors {
+		for _, sensor := range chip.Temperatures {
+			w.gauge("nex_sensor_temperature_celsius", "Hardware monitor temperatures.", sensor.Current, "chip", chip.Name, "device", chip.Device, "sensor", sensor.Label)
+		}
+	}
+	for _, chip := range stats.Sensors {
+		for _, fan := range chip.Fans {
+			w.gauge("nex_sensor_fan_rpm", "Hardware monitor fan speeds.", float64(fan.RPM), "chip", chip.Name, "device", chip.Device, "sensor", fan.Label)
+		}
+	}
+	for _, chip := range stats.Sensors {
+		for _, voltage := range chip.Voltages {
+			w.gauge("nex_sensor_voltage_volts", "Hardware monitor voltages.", voltage.Volts, "chip", chip.Name, "device", chip.Device, "sensor", voltage.Label)
+		}
+	}
+
+	w.gauge("nex_battery_present", "Whether the system has a battery.", boolValue(stats.Battery.Present))
+	if stats.Battery.Present {
+		w.gauge("nex_battery_percent", "Battery charge.", float64(stats.Battery.Percentage))
+		w.gauge("nex_battery_plugged_in", "Whether AC power is connected.", boolValue(stats.Battery.PluggedIn))
+		w.gauge("nex_battery_energy_rate_watts", "Battery charge or discharge rate.", stats.Battery.EnergyRate)
+	}
+	for _, devices := range [][]models.BatteryDevice{stats.Battery.Batteries, stats.Battery.Peripherals} {
+		for _, device := range devices {
+			w.gauge("nex_device_battery_percent", "Charge of every battery and peripheral.", device.Percentage, "id", device.ID, "type", device.Type, "model", device.Model)
+		}
+	}
+
+	w.gauge("nex_wifi_connected", "Whether Wi-Fi is connected.", boolValue(stats.Wifi.Connected))
+	if stats.Wifi.Connected {
+		w.gauge("nex_wifi_signal_percent", "Signal strength of the current access point.", float64(stats.Wifi.Strength), "ssid", stats.Wifi.SSID)
+	}
+
+	w.gauge("nex_volume_percent", "Default sink volume.", float64(stats.Volume))
+	w.gauge("nex_volume_muted", "Whether the default sink is muted.", boolValue(stats.Muted))
+	for _, device := range stats.Backlights {
+		w.gauge("nex_backlight_percent", "Backlight brightness.", float64(device.Percentage), "device", device.ID, "type", device.Type)
+	}
+
+	for _, player := range stats.Audio {
+		w.gauge("nex_player_info", "Known media players.", 1, "player", player.ID, "name", player.Name)
+	}
+	for _, player := range stats.Audio {
+		w.gauge("nex_player_playing", "Whether the player is playing.", boolValue(player.Playing), "player", player.ID, "name", player.Name)
+	}
+	for _, player := range stats.Audio {
+		w.gauge("nex_player_position_seconds", "Playback position.", float64(player.Timestamp), "player", player.ID, "name", player.Name)
+	}
+	for _, player := range stats.Audio {
+		w.gauge("nex_player_duration_seconds", "Track length.", float64(player.Duration), "player", player.ID, "name", player.Name)
+	}
+}
+
+func writeServerMetrics(w *metricWriter, wsManager *ws.Manager) {
+	server := wsManager.ServerStats()
+
+	w.gauge("nex_server_clients", "Connected websocket clients.", float64(server.Clients))
+	w.sample("nex_server_broadcast_duration_seconds", "summary", "Time spent collecting and sending stats.", "_sum", server.BroadcastSeconds)
+	w.sample("nex_server_broadcast_duration_seconds", "summary", "Time spent collecting and sending stats.", "_count", float64(server.Broadcasts))
+
+	collectors := make([]string, 0, len(server.CollectorErrors))
+	for collector := range server.CollectorErrors {
+		collectors = append(collectors, collector)
+	}
+	sort.Strings(collectors)
+	w.declare("nex_server_collector_errors_total", "counter", "Failed collector runs.")
+	for _, collector := range collectors {
+		w.counter("nex_server_collector_errors_total", "Failed collector runs.", float64(server.CollectorErrors[collector]), "collector", collector)
+	}
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// metricWriter renders the Prometheus text format. HELP and TYPE are
+// written before the first sample of each metric.
+type metricWriter struct {
+	strings.Builder
+	declared map[string]bool
+}
+
+func newMetricWriter() *metricWriter {
+	return &metricWriter{declared: make(map[string]bool)}
+}
+
+func (w *metricWriter) gauge(name, help string, value float64, labels ...string) {
+	w.sample(name, "gauge", help, "", value, labels...)
+}
+
+func (w *metricWriter) counter(name, help string, value float64, labels ...string) {
+	w.sample(name, "counter", help, "", value, labels...)
+}
+
+func (w *metricWriter) declare(name, kind, help string) {
+	if w.declared[name] {
+		return
+	}
+	w.declared[name] = true
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func (w *metricWriter) sample(name, kind, help, suffix string, value float64, labels ...string) {
+	w.declare(name, kind, help)
+
+	w.WriteString(name + suffix)
+	if len(labels) > 0 {
+		w.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		w.WriteString("}")
+	}
+	w.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
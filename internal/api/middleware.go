@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func bearerClaims(c *gin.Context) (*auth.Claims, string) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return nil, "missing auth header"
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, "invalid auth header"
+	}
+
+	claims, err := auth.ValidateToken(parts[1])
+	if err != nil || claims.Type != "login" {
+		return nil, "invalid token"
+	}
+
+	return claims, ""
+}
+
+// requireAuth accepts a login token and, when scope is set, requires the
+// user to hold it. The claims are stored under "claims" for the handlers.
+func requireAuth(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, reason := bearerClaims(c)
+		if claims == nil {
+			abortError(c, http.StatusUnauthorized, models.ErrUnauthorized, reason, nil)
+			return
+		}
+
+		if scope != "" && !claims.HasScope(scope) {
+			abortError(c, http.StatusForbidden, models.ErrForbidden, "missing scope "+scope, gin.H{"scope": scope})
+			return
+		}
+
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+func rejectReadOnly(c *gin.Context) {
+	if config.Current.ReadOnly {
+		abortError(c, http.StatusForbidden, models.ErrReadOnly, "server is read only", nil)
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,342 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const specVersion = "1.0.0"
+
+// Access levels of a route besides the scopes.
+const (
+	accessPublic  = "public"
+	accessLogin   = "login"
+	accessMetrics = "metrics"
+)
+
+// routeSpec documents one REST route. query, body and response are zero
+// values of the types the handler binds and encodes, the schemas are
+// derived from them.
+type routeSpec struct {
+	method  string
+	path    string
+	summary string
+	access  string
+	query   interface{}
+	// params are further string query parameters read with c.Query.
+	params   []string
+	body     interface{}
+	response interface{}
+	status   int
+	// content is the media type of responses that are not JSON.
+	content string
+	// optional routes are only registered with some config, like /metrics.
+	optional bool
+}
+
+var routeSpecs = []routeSpec{
+	{method: "POST", path: "/v1/login", summary: "Log in with the configured user", access: accessPublic, body: models.LoginRequest{}, response: models.LoginResponse{}},
+	{method: "GET", path: "/v1/websocket", summary: "Get a websocket token and URL", access: accessLogin, response: models.WebSocketResponse{}},
+	{method: "GET", path: "/v1/monitor/:uuid/ws", summary: "Websocket, see the AsyncAPI document", access: accessPublic, status: http.StatusSwitchingProtocols},
+	{method: "GET", path: "/v1/img/tmp/:encodedPath", summary: "Cover art of a player", access: accessPublic, content: "image/*"},
+
+	{method: "GET", path: "/v1/stats", summary: "Last stats sample", access: accessLogin, response: models.SystemStats{}},
+	{method: "GET", path: "/v1/stats/:topic", summary: "One top level field of the stats", access: accessLogin, response: map[string]interface{}{}},
+	{method: "GET", path: "/v1/stream", summary: "Websocket events as Server-Sent Events", access: accessLogin, params: []string{"events"}, content: "text/event-stream"},
+	{method: "GET", path: "/v1/history", summary: "Recorded metric values", access: accessLogin, query: models.HistoryRequest{}, response: models.History{}},
+	{method: "GET", path: "/v1/alerts", summary: "Firing and recently resolved alerts", access: accessLogin, response: models.AlertList{}},
+	{method: "GET", path: "/metrics", summary: "Prometheus metrics", access: accessMetrics, content: "text/plain", optional: true},
+
+	{method: "GET", path: "/v1/power", summary: "Power capabilities and pending action", access: auth.ScopeAdmin, response: models.PowerState{}},
+	{method: "POST", path: "/v1/power/cancel", summary: "Cancel the pending power action", access: auth.ScopeAdmin, response: models.PowerState{}},
+	{method: "POST", path: "/v1/power/:action", summary: "Run or schedule a power action", access: auth.ScopeAdmin, body: models.PowerRequest{}, response: models.PowerState{}},
+	{method: "GET", path: "/v1/screenshot", summary: "Capture the screen", access: auth.ScopeScreenshot, query: models.ScreenshotRequest{}, content: "image/*"},
+
+	{method: "GET", path: "/v1/apps", summary: "Installed applications", access: accessLogin, response: []models.Application{}},
+	{method: "POST", path: "/v1/apps/:id/launch", summary: "Launch an application", access: accessLogin, status: http.StatusNoContent},
+	{method: "GET", path: "/v1/windows", summary: "Open windows", access: accessLogin, response: []models.Window{}},
+
+	{method: "GET", path: "/v1/files", summary: "Configured file roots", access: auth.ScopeFiles, response: []string{}},
+	{method: "GET", path: "/v1/files/:root/*path", summary: "Stat a path, or download it with download=true", access: auth.ScopeFiles, params: []string{"download"}, response: models.FileEntry{}},
+	{method: "PUT", path: "/v1/files/:root/*path", summary: "Upload a chunk", access: auth.ScopeFiles, params: []string{"offset", "complete"}, content: "application/octet-stream", response: models.UploadStatus{}},
+	{method: "POST", path: "/v1/files/:root/*path", summary: "Create a directory", access: auth.ScopeFiles, status: http.StatusCreated},
+	{method: "PATCH", path: "/v1/files/:root/*path", summary: "Rename within the root", access: auth.ScopeFiles, body: models.FileRename{}, status: http.StatusNoContent},
+	{method: "DELETE", path: "/v1/files/:root/*path", summary: "Delete a path", access: auth.ScopeFiles, params: []string{"recursive"}, status: http.StatusNoContent},
+
+	{method: "POST", path: "/v1/media/:player/:action", summary: "Control a player, seek takes a position", access: accessLogin, body: models.SeekRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/volume", summary: "Set or step the volume", access: accessLogin, body: models.VolumeRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/mute", summary: "Mute the default sink", access: accessLogin, body: models.MuteRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/mic-mute", summary: "Mute the default source", access: accessLogin, body: models.MuteRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/default-sink", summary: "Change the default sink", access: accessLogin, body: models.DeviceRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/default-source", summary: "Change the default source", access: accessLogin, body: models.DeviceRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/audio/streams/:index", summary: "Change an application stream", access: accessLogin, body: models.StreamRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/brightness/:device", summary: "Set a backlight", access: accessLogin, body: models.BrightnessRequest{}, status: http.StatusNoContent},
+	{method: "POST", path: "/v1/actions/:event", summary: "Run any websocket action", access: accessLogin, body: models.ActionRequest{}, status: http.StatusNoContent},
+
+	{method: "POST", path: "/v1/webhooks/test", summary: "Queue a test event for the webhooks", access: auth.ScopeAdmin, response: map[string]string{}, status: http.StatusAccepted},
+	{method: "GET", path: "/v1/openapi.json", summary: "This document", access: accessPublic, response: map[string]interface{}{}},
+	{method: "GET", path: "/v1/asyncapi.json", summary: "The websocket events as AsyncAPI", access: accessPublic, response: map[string]interface{}{}},
+}
+
+// serverEvents are sent by the server, the payload is stringified in
+// args[0].
+var serverEvents = []struct {
+	event   string
+	payload interface{}
+}{
+	{"stats", models.SystemStats{}},
+	{"notification", models.Notification{}},
+	{"notification-closed", models.NotificationClosed{}},
+	{"clipboard-changed", models.ClipboardContent{}},
+	{"screenshot", models.Screenshot{}},
+	{"wifi-networks", models.WifiNetworks{}},
+	{"sound-devices", models.AudioDevices{}},
+	{"apps", []models.Application{}},
+	{"windows", []models.Window{}},
+	{"commands", []models.CommandInfo{}},
+	{"command-started", models.CommandRun{}},
+	{"command-output", models.CommandOutput{}},
+	{"command-exit", models.CommandExit{}},
+	{"sftp-activity", models.SFTPActivity{}},
+	{"history", models.History{}},
+	{"alert", models.Alert{}},
+	{"alert-resolved", models.Alert{}},
+	{"alerts", models.AlertList{}},
+	{"track-changed", models.AudioState{}},
+	{"power-source", models.PowerSource{}},
+	{"session expiring ", ""},
+	{"error", models.APIError{}},
+}
+
+// clientQueries are client events answered to the sender only, next to
+// the actions listed by ws.Actions.
+var clientQueries = []struct {
+	event string
+	args  string
+	reply string
+}{
+	{"auth", "websocket token", ""},
+	{"screenshot", "monitor, width, format, quality", "screenshot"},
+	{"wifi-scan", "", "wifi-networks"},
+	{"sound-devices", "", "sound-devices"},
+	{"apps", "", "apps"},
+	{"windows", "", "windows"},
+	{"commands", "", "commands"},
+	{"run-command", "name, extra arguments", "command-started"},
+	{"history", "metric, from, to, step", "history"},
+	{"alerts", "", "alerts"},
+}
+
+var pathParam = regexp.MustCompile(`[:*]([a-zA-Z]+)`)
+
+// setupSpecRoutes serves the API documents, it has to run after every other
+// route was added. openapi_test.go checks them against the registered routes
+// and the bodies the handlers send.
+func setupSpecRoutes(r *gin.Engine) {
+	schemas := newSchemaRegistry()
+	openapi := buildOpenAPI(schemas)
+	asyncapi := buildAsyncAPI(schemas)
+	if schemas.err != nil {
+		log.Printf("API documents incomplete: %v", schemas.err)
+	}
+
+	r.GET("/v1/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, openapi)
+	})
+	r.GET("/v1/asyncapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, asyncapi)
+	})
+}
+
+func buildOpenAPI(schemas *schemaRegistry) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, spec := range routeSpecs {
+		path := pathParam.ReplaceAllString(spec.path, "{$1}")
+
+		operation := map[string]interface{}{
+			"summary":    spec.summary,
+			"parameters": schemas.parameters(spec.query),
+			"responses":  responses(schemas, spec),
+		}
+		for _, name := range spec.params {
+			operation["parameters"] = append(operation["parameters"].([]interface{}), map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(spec.path, -1) {
+			operation["parameters"] = append(operation["parameters"].([]interface{}), map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+
+		switch {
+		case spec.body != nil:
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.of(spec.body)},
+				},
+			}
+		case spec.method == "PUT":
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/octet-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+				},
+			}
+		}
+
+		switch spec.access {
+		case accessPublic:
+			operation["security"] = []interface{}{}
+		case accessMetrics:
+			operation["security"] = []interface{}{map[string]interface{}{"metricsToken": []string{}}}
+		case accessLogin:
+			operation["security"] = []interface{}{map[string]interface{}{"loginToken": []string{}}}
+		default:
+			operation["security"] = []interface{}{map[string]interface{}{"loginToken": []string{}}}
+			operation["x-scope"] = spec.access
+		}
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(spec.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "nex-server",
+			"version":     specVersion,
+			"description": "REST API of nex-server. The websocket events are described in /v1/asyncapi.json.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]interface{}{
+				"loginToken":   map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"metricsToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func responses(schemas *schemaRegistry, spec routeSpec) map[string]interface{} {
+	status := spec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case spec.response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemas.of(spec.response)},
+		}
+	case spec.content != "" && spec.method == "GET":
+		success["content"] = map[string]interface{}{
+			spec.content: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+		}
+	}
+
+	return map[string]interface{}{
+		fmt.Sprint(status): success,
+		"default": map[string]interface{}{
+			"description": "Error, with a stable code in the body",
+			"headers": map[string]interface{}{
+				requestIDHeader: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.of(models.ErrorResponse{})},
+			},
+		},
+	}
+}
+
+func buildAsyncAPI(schemas *schemaRegistry) map[string]interface{} {
+	messages := map[string]interface{}{}
+	message := func(event, summary string, data map[string]interface{}) map[string]interface{} {
+		payload := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"event": map[string]interface{}{"type": "string", "enum": []string{event}},
+				"args":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+			"required": []string{"event", "args"},
+		}
+		msg := map[string]interface{}{"name": event, "summary": summary, "payload": payload}
+		if data != nil {
+			// The schema of the JSON string in args[0].
+			msg["x-args-payload"] = data
+		}
+		return msg
+	}
+
+	serverMessages := []interface{}{}
+	for _, e := range serverEvents {
+		key := "server-" + e.event
+		messages[key] = message(e.event, "Sent by the server, args[0] is the payload as a JSON string", schemas.of(e.payload))
+		serverMessages = append(serverMessages, map[string]interface{}{"$ref": "#/components/messages/" + key})
+	}
+
+	clientMessages := []interface{}{}
+	for _, q := range clientQueries {
+		key := "client-" + q.event
+		summary := "Arguments: " + q.args
+		if q.args == "" {
+			summary = "No arguments"
+		}
+		if q.reply != "" {
+			summary += ". Answered with " + q.reply
+		}
+		messages[key] = message(q.event, summary, nil)
+		clientMessages = append(clientMessages, map[string]interface{}{"$ref": "#/components/messages/" + key})
+	}
+	for _, action := range ws.Actions() {
+		key := "client-" + action.Event
+		messages[key] = message(action.Event, fmt.Sprintf("Action, at least %d arguments, permission %s", action.Args, action.Permission), nil)
+		clientMessages = append(clientMessages, map[string]interface{}{"$ref": "#/components/messages/" + key})
+	}
+
+	return map[string]interface{}{
+		"asyncapi": "2.6.0",
+		"info": map[string]interface{}{
+			"title":       "nex-server websocket",
+			"version":     specVersion,
+			"description": "Get the URL and token from GET /v1/websocket, connect and send the auth event first.",
+		},
+		"channels": map[string]interface{}{
+			"/v1/monitor/{uuid}/ws": map[string]interface{}{
+				"parameters": map[string]interface{}{
+					"uuid": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+				"publish": map[string]interface{}{
+					"summary": "Events sent by the client",
+					"message": map[string]interface{}{"oneOf": clientMessages},
+				},
+				"subscribe": map[string]interface{}{
+					"summary": "Events sent by the server",
+					"message": map[string]interface{}{"oneOf": serverMessages},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"messages": messages,
+			"schemas":  schemas.schemas,
+		},
+	}
+}
@@ -0,0 +1,412 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// samplePaths fills the parameters of every route with a parameter.
+var samplePaths = map[string]string{
+	"/v1/img/tmp/:encodedPath":  "/v1/img/tmp/" + base64.URLEncoding.EncodeToString([]byte("/nonexistent.png")),
+	"/v1/stats/:topic":          "/v1/stats/battery",
+	"/v1/power/:action":         "/v1/power/suspend",
+	"/v1/apps/:id/launch":       "/v1/apps/nex-test.desktop/launch",
+	"/v1/files/:root/*path":     "/v1/files/test/hello.txt",
+	"/v1/media/:player/:action": "/v1/media/nex-test/play",
+	"/v1/audio/streams/:index":  "/v1/audio/streams/0",
+	"/v1/brightness/:device":    "/v1/brightness/nex-test",
+	"/v1/actions/:event":        "/v1/actions/keep-awake",
+}
+
+var sampleQueries = map[string]string{
+	"/v1/history": "metric=cpu",
+	// An unsupported format fails before anything is captured.
+	"/v1/screenshot": "format=none",
+}
+
+var sampleBodies = map[string]string{
+	"POST /v1/login":                `{"username": "nex", "password": "secret"}`,
+	"POST /v1/power/:action":        `{"delay": 30}`,
+	"POST /v1/audio/volume":         `{"volume": 40}`,
+	"POST /v1/audio/mute":           `{"mode": "on"}`,
+	"POST /v1/audio/mic-mute":       `{"mode": "on"}`,
+	"POST /v1/audio/default-sink":   `{"name": "nex-test"}`,
+	"POST /v1/audio/default-source": `{"name": "nex-test"}`,
+	"POST /v1/audio/streams/:index": `{"volume": 80, "mute": "on"}`,
+	"POST /v1/brightness/:device":   `{"percent": 60}`,
+	"POST /v1/actions/:event":       `{"args": ["on"]}`,
+	"PUT /v1/files/:root/*path":     "hello",
+	"PATCH /v1/files/:root/*path":   `{"to": "/renamed.txt"}`,
+}
+
+// mustSucceed are the routes that work in the test setup, so their success
+// bodies are checked and not only the error envelope.
+var mustSucceed = []string{
+	"POST /v1/login",
+	"GET /v1/websocket",
+	"GET /v1/stats",
+	"GET /v1/stats/:topic",
+	"GET /v1/history",
+	"GET /v1/alerts",
+	"GET /metrics",
+	"GET /v1/power",
+	"GET /v1/apps",
+	"GET /v1/files",
+	"GET /v1/files/:root/*path",
+	"GET /v1/openapi.json",
+	"GET /v1/asyncapi.json",
+}
+
+var (
+	testServerOnce   sync.Once
+	testServerDir    string
+	testServerRouter *gin.Engine
+	testServerToken  string
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testServerDir != "" {
+		os.RemoveAll(testServerDir)
+	}
+	os.Exit(code)
+}
+
+// testServer sets up the routes on a read only server, so none of the
+// actions reaches the desktop running the tests.
+func testServer(t *testing.T) (*gin.Engine, string) {
+	t.Helper()
+
+	testServerOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "nex-api-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testServerDir = dir
+		os.MkdirAll(filepath.Join(dir, "files"), 0755)
+		os.WriteFile(filepath.Join(dir, "files", "hello.txt"), []byte("hello"), 0644)
+
+		cfg := &config.Config{ReadOnly: true, JWTSecret: "test-secret"}
+		cfg.User.Username = "nex"
+		cfg.User.Password = "secret"
+		cfg.User.Scopes = []string{auth.ScopeAdmin}
+		cfg.System.TmpDirectory = filepath.Join(dir, "tmp")
+		cfg.System.DataDirectory = dir
+		cfg.Files.Roots = map[string]string{"test": filepath.Join(dir, "files")}
+		cfg.Metrics = config.MetricsConfig{Enable: true, Token: "metrics-token"}
+		config.Current = cfg
+
+		gin.SetMode(gin.TestMode)
+		wsManager := ws.NewManager()
+		go wsManager.Run()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+			if _, ok := wsManager.Snapshot(); ok {
+				break
+			}
+		}
+
+		testServerRouter = gin.New()
+		SetupRoutes(testServerRouter, wsManager)
+		testServerToken, _ = auth.GenerateLoginToken(cfg.User.Username, cfg.User.Scopes)
+	})
+
+	if testServerRouter == nil || testServerToken == "" {
+		t.Fatal("test server setup failed")
+	}
+	return testServerRouter, testServerToken
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	r, _ := testServer(t)
+
+	documented := map[string]routeSpec{}
+	for _, spec := range routeSpecs {
+		documented[spec.method+" "+spec.path] = spec
+	}
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if _, ok := documented[key]; !ok {
+			t.Errorf("route %s is missing from routeSpecs", key)
+		}
+	}
+	for key, spec := range documented {
+		if !registered[key] && !spec.optional {
+			t.Errorf("documented route %s is not registered", key)
+		}
+	}
+}
+
+func TestDocumentsBuild(t *testing.T) {
+	schemas := newSchemaRegistry()
+	buildOpenAPI(schemas)
+	buildAsyncAPI(schemas)
+	if schemas.err != nil {
+		t.Fatal(schemas.err)
+	}
+}
+
+// TestRoutesMatchDocument calls every documented route and checks the
+// request it sends and the response it gets against the served OpenAPI
+// document. Failures must use the error envelope.
+func TestRoutesMatchDocument(t *testing.T) {
+	r, token := testServer(t)
+
+	var doc map[string]interface{}
+	w := serve(r, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	v := schemaValidator{schemas: lookup(doc, "components", "schemas")}
+
+	succeeded := map[string]bool{}
+	for _, spec := range routeSpecs {
+		key := spec.method + " " + spec.path
+		// Upgrades and streams do not end, they are not plain requests.
+		if spec.status == http.StatusSwitchingProtocols || spec.content == "text/event-stream" {
+			continue
+		}
+
+		t.Run(key, func(t *testing.T) {
+			operation := lookup(doc, "paths", pathParam.ReplaceAllString(spec.path, "{$1}"), strings.ToLower(spec.method))
+			if operation == nil {
+				t.Fatal("missing from openapi.json")
+			}
+
+			path := spec.path
+			if strings.ContainsAny(path, ":*") {
+				path = samplePaths[spec.path]
+				if path == "" {
+					t.Fatal("no sample path in samplePaths")
+				}
+			}
+			if query := sampleQueries[spec.path]; query != "" {
+				path += "?" + query
+			}
+
+			body := sampleBodies[key]
+			if body != "" && spec.body != nil {
+				var value interface{}
+				if err := json.Unmarshal([]byte(body), &value); err != nil {
+					t.Fatalf("sample body: %v", err)
+				}
+				schema := lookup(operation, "requestBody", "content", "application/json", "schema")
+				for _, problem := range v.check(schema, value, "request") {
+					t.Error(problem)
+				}
+			}
+
+			req := httptest.NewRequest(spec.method, path, strings.NewReader(body))
+			switch spec.access {
+			case accessPublic:
+			case accessMetrics:
+				req.Header.Set("Authorization", "Bearer metrics-token")
+			default:
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			if spec.body != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := serve(r, req)
+
+			status := spec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			switch {
+			case w.Code == status:
+				succeeded[key] = true
+				response := lookup(operation, "responses", fmt.Sprint(status))
+				if response == nil {
+					t.Fatalf("status %d is not documented", status)
+				}
+				schema := lookup(response, "content", "application/json", "schema")
+				if schema == nil {
+					if spec.response != nil {
+						t.Error("JSON response without a schema")
+					}
+					return
+				}
+				var value interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+					t.Fatalf("response is not JSON: %v", err)
+				}
+				for _, problem := range v.check(schema, value, "response") {
+					t.Error(problem)
+				}
+
+			case w.Code >= http.StatusBadRequest:
+				var value interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+					t.Fatalf("error %d is not JSON: %s", w.Code, w.Body)
+				}
+				schema := lookup(operation, "responses", "default", "content", "application/json", "schema")
+				for _, problem := range v.check(schema, value, "error") {
+					t.Error(problem)
+				}
+				var envelope models.ErrorResponse
+				json.Unmarshal(w.Body.Bytes(), &envelope)
+				if envelope.Error.Code == "" || envelope.Error.RequestID != w.Header().Get(requestIDHeader) {
+					t.Errorf("error %d without code or request id: %s", w.Code, w.Body)
+				}
+
+			default:
+				t.Errorf("undocumented status %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	for _, key := range mustSucceed {
+		if !succeeded[key] {
+			t.Errorf("%s did not succeed", key)
+		}
+	}
+}
+
+func serve(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// lookup walks nested objects of a decoded JSON document, nil when a key is
+// missing.
+func lookup(value interface{}, keys ...string) map[string]interface{} {
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	object, _ := value.(map[string]interface{})
+	return object
+}
+
+// schemaValidator checks decoded JSON against the subset of OpenAPI schemas
+// the schemaRegistry produces.
+type schemaValidator struct {
+	schemas map[string]interface{}
+}
+
+func (v schemaValidator) check(schema map[string]interface{}, value interface{}, at string) []string {
+	if schema == nil {
+		return []string{at + ": no schema"}
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := v.schemas[name].(map[string]interface{})
+		if !ok {
+			return []string{at + ": unknown schema " + ref}
+		}
+		return v.check(resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true || schema["type"] == nil && schema["allOf"] == nil {
+			return nil
+		}
+		return []string{at + ": null is not allowed"}
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		problems := []string{}
+		for _, sub := range all {
+			sub, _ := sub.(map[string]interface{})
+			problems = append(problems, v.check(sub, value, at)...)
+		}
+		return problems
+	}
+
+	wrongType := []string{fmt.Sprintf("%s: %T is not %v", at, value, schema["type"])}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return wrongType
+		}
+		return v.checkObject(schema, object, at)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return wrongType
+		}
+		problems := []string{}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			problems = append(problems, v.check(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		if _, ok := value.(string); !ok {
+			return wrongType
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return wrongType
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return wrongType
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return wrongType
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			return []string{fmt.Sprintf("%s: %v is below %v", at, number, minimum)}
+		}
+	}
+	return nil
+}
+
+// checkObject requires the required fields and refuses fields a struct
+// does not declare, so a handler encoding another type is caught.
+func (v schemaValidator) checkObject(schema, object map[string]interface{}, at string) []string {
+	problems := []string{}
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		if _, ok := object[name.(string)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing required %s", at, name))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, _ := schema["additionalProperties"].(map[string]interface{})
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field, ok := properties[name].(map[string]interface{})
+		if !ok {
+			field = additional
+		}
+		switch {
+		case field != nil:
+			problems = append(problems, v.check(field, object[name], at+"."+name)...)
+		case properties != nil:
+			problems = append(problems, fmt.Sprintf("%s: undocumented field %s", at, name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupPowerRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	power := r.Group("/v1/power", requireAuth(auth.ScopeAdmin))
+
+	power.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, wsManager.Power.State())
+	})
+
+	power.POST("/cancel", func(c *gin.Context) {
+		if !execute(c, wsManager, "power-cancel") {
+			return
+		}
+		c.JSON(http.StatusOK, wsManager.Power.State())
+	})
+
+	power.POST("/:action", func(c *gin.Context) {
+		var req models.PowerRequest
+		if c.Request.ContentLength > 0 {
+			if !bindJSON(c, &req) {
+				return
+			}
+		}
+
+		if !execute(c, wsManager, "power", c.Param("action"), strconv.Itoa(req.Delay)) {
+			return
+		}
+		c.JSON(http.StatusOK, wsManager.Power.State())
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// schemaRegistry derives JSON schemas from the Go types that are actually
+// encoded, so the documents cannot drift from the models. Named structs are
+// collected once under components and referenced from everywhere else.
+type schemaRegistry struct {
+	schemas map[string]interface{}
+	err     error
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{schemas: make(map[string]interface{})}
+}
+
+// of returns the schema of the type of value, nil for no value.
+func (s *schemaRegistry) of(value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+	return s.schema(reflect.TypeOf(value))
+}
+
+func (s *schemaRegistry) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(s.schema(t.Elem()))
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nullable(map[string]interface{}{"type": "string", "format": "byte"})
+		}
+		return nullable(map[string]interface{}{"type": "array", "items": s.schema(t.Elem())})
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return nullable(map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := t.Name()
+		if _, ok := s.schemas[name]; !ok {
+			s.schemas[name] = nil
+			s.schemas[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	if s.err == nil {
+		s.err = fmt.Errorf("no schema for %s", t)
+	}
+	return map[string]interface{}{}
+}
+
+// nullable allows null, which encoding/json writes for nil pointers, slices
+// and maps. A reference takes no siblings, so it is wrapped in allOf.
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]interface{}{"nullable": true, "allOf": []interface{}{schema}}
+	}
+	schema["nullable"] = true
+	return schema
+}
+
+func (s *schemaRegistry) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	s.fields(t, properties, &required)
+
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// fields adds the JSON fields of t, flattening embedded structs the way
+// encoding/json does. Only fields bound as required are marked so.
+func (s *schemaRegistry) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.fields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = s.schema(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// parameters describes the form tagged fields of a query struct.
+func (s *schemaRegistry) parameters(value interface{}) []interface{} {
+	params := []interface{}{}
+	if value == nil {
+		return params
+	}
+	t := reflect.TypeOf(value)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "query",
+			"required": strings.Contains(field.Tag.Get("binding"), "required"),
+			"schema":   s.schema(field.Type),
+		})
+	}
+	return params
+}
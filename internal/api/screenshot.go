@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/system"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupScreenshotRoutes(r *gin.Engine) {
+	r.GET("/v1/screenshot", requireAuth(auth.ScopeScreenshot), func(c *gin.Context) {
+		var req models.ScreenshotRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			abortError(c, http.StatusBadRequest, models.ErrInvalidRequest, "invalid query: "+err.Error(), nil)
+			return
+		}
+
+		path, mime, err := system.CaptureScreenshot(req)
+		if err != nil {
+			abortError(c, http.StatusInternalServerError, models.ErrInternal, err.Error(), nil)
+			return
+		}
+		defer os.Remove(path)
+
+		c.Header("Content-Type", mime)
+		c.Header("Cache-Control", "no-store")
+		c.File(path)
+	})
+}
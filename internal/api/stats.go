@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupStatsRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	r.GET("/v1/stats", requireAuth(""), func(c *gin.Context) {
+		stats, ok := wsManager.Snapshot()
+		if !ok {
+			abortError(c, http.StatusServiceUnavailable, models.ErrUnavailable, "no stats collected yet", nil)
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	// Any top level field of the stats, by its JSON name: battery, wifi,
+	// audio, sensors, processes, ...
+	r.GET("/v1/stats/:topic", requireAuth(""), func(c *gin.Context) {
+		stats, ok := wsManager.Snapshot()
+		if !ok {
+			abortError(c, http.StatusServiceUnavailable, models.ErrUnavailable, "no stats collected yet", nil)
+			return
+		}
+
+		fields, err := statsFields(stats)
+		if err != nil {
+			abortError(c, http.StatusInternalServerError, models.ErrInternal, err.Error(), nil)
+			return
+		}
+		field, ok := fields[c.Param("topic")]
+		if !ok {
+			topics := make([]string, 0, len(fields))
+			for topic := range fields {
+				topics = append(topics, topic)
+			}
+			sort.Strings(topics)
+			abortError(c, http.StatusNotFound, models.ErrNotFound, "unknown topic", gin.H{"topics": topics})
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", field)
+	})
+
+	// The events of the websocket as Server-Sent Events. Each event carries
+	// its payload as data, the optional events query limits the stream to a
+	// comma separated list of event names.
+	r.GET("/v1/stream", requireAuth(""), func(c *gin.Context) {
+		claims := c.MustGet("claims").(*auth.Claims)
+
+		filter := map[string]bool{}
+		for _, name := range strings.Split(c.Query("events"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				filter[name] = true
+			}
+		}
+
+		client := wsManager.Subscribe(claims)
+		defer func() {
+			wsManager.Unregister <- client
+		}()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		keepAlive := time.NewTicker(30 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-keepAlive.C:
+				io.WriteString(w, ": keep-alive\n\n")
+				return true
+			case <-client.Done():
+				return false
+			case msg := <-client.Send:
+				var event models.StatsEvent
+				if err := json.Unmarshal(msg, &event); err != nil {
+					return true
+				}
+				event.Event = strings.TrimSpace(event.Event)
+				if len(filter) > 0 && !filter[event.Event] {
+					return true
+				}
+				c.SSEvent(event.Event, eventData(event.Args))
+				return true
+			}
+		})
+	})
+}
+
+func statsFields(stats models.SystemStats) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// eventData unwraps the stringified payload of the websocket message, other
+// arguments are sent as a JSON array.
+func eventData(args []string) string {
+	if len(args) == 1 && json.Valid([]byte(args[0])) {
+		return args[0]
+	}
+	data, _ := json.Marshal(args)
+	return string(data)
+}
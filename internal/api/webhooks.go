@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+	"nex-server/internal/auth"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupWebhookRoutes(r *gin.Engine, wsManager *ws.Manager) {
+	// Sends a "test" event to every webhook subscribed to it, to check the
+	// receiving end without waiting for a real event.
+	r.POST("/v1/webhooks/test", requireAuth(auth.ScopeAdmin), func(c *gin.Context) {
+		if wsManager.Webhooks == nil {
+			abortError(c, http.StatusNotFound, models.ErrNotFound, "no webhooks configured", nil)
+			return
+		}
+		wsManager.Webhooks.Dispatch("test", gin.H{"message": "Test event from nex-server", "timestamp": time.Now().Unix()})
+		c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
+	})
+}
@@ -8,17 +8,34 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	ScopeAdmin      = "admin"
+	ScopeScreenshot = "screenshot"
+	ScopeFiles      = "files"
+)
+
 type Claims struct {
-	Username string `json:"username"`
-	Type     string `json:"type"`
+	Username string   `json:"username"`
+	Type     string   `json:"type"`
+	Scopes   []string `json:"scopes,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateLoginToken(username string) (string, error) {
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func GenerateLoginToken(username string, scopes []string) (string, error) {
 	expirationTime := time.Now().Add(30 * 24 * time.Hour)
 	claims := &Claims{
 		Username: username,
 		Type:     "login",
+		Scopes:   scopes,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -27,11 +44,12 @@ func GenerateLoginToken(username string) (string, error) {
 	return token.SignedString([]byte(config.Current.JWTSecret))
 }
 
-func GenerateWSToken(username string) (string, error) {
+func GenerateWSToken(username string, scopes []string) (string, error) {
 	expirationTime := time.Now().Add(20 * time.Minute)
 	claims := &Claims{
 		Username: username,
 		Type:     "websocket",
+		Scopes:   scopes,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -20,28 +20,151 @@ type Config struct {
 		UploadLimit           int64  `yaml:"upload_limit"`
 	} `yaml:"api"`
 	User struct {
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
+		Username       string   `yaml:"username"`
+		Password       string   `yaml:"password"`
+		Scopes         []string `yaml:"scopes"`
+		AuthorizedKeys []string `yaml:"authorized_keys"`
 	} `yaml:"user"`
 	System struct {
-		LogDirectory           string `yaml:"log_directory"`
-		TmpDirectory           string `yaml:"tmp_directory"`
-		Timezone               string `yaml:"timezone"`
-		DiskCheckInterval      int    `yaml:"disk_check_interval"`
-		ActivitySendInterval   int    `yaml:"activity_send_interval"`
-		CheckPermissionsOnBoot bool   `yaml:"check_permissions_on_boot"`
-		EnableLogRotate        bool   `yaml:"enable_log_rotate"`
-		WebsocketLogCount      int    `yaml:"websocket_log_count"`
-		SFTP                   struct{}
+		LogDirectory           string     `yaml:"log_directory"`
+		TmpDirectory           string     `yaml:"tmp_directory"`
+		DataDirectory          string     `yaml:"data_directory"`
+		Timezone               string     `yaml:"timezone"`
+		DiskCheckInterval      int        `yaml:"disk_check_interval"`
+		ActivitySendInterval   int        `yaml:"activity_send_interval"`
+		CheckPermissionsOnBoot bool       `yaml:"check_permissions_on_boot"`
+		EnableLogRotate        bool       `yaml:"enable_log_rotate"`
+		WebsocketLogCount      int        `yaml:"websocket_log_count"`
+		SFTP                   SFTPConfig `yaml:"sftp"`
 	} `yaml:"system"`
 	BindAddress            string `yaml:"bind_address"`
 	BindPort               int    `yaml:"bind_port"`
 	ReadOnly               bool   `yaml:"read_only"`
 	CrashDetection         struct{}
-	Enabled                bool   `yaml:"enabled"`
-	DetectCleanExitAsCrash bool   `yaml:"detect_clean_exit_as_crash"`
-	Timeout                int    `yaml:"timeout"`
-	JWTSecret              string `yaml:"jwt_secret"`
+	Enabled                bool                     `yaml:"enabled"`
+	DetectCleanExitAsCrash bool                     `yaml:"detect_clean_exit_as_crash"`
+	Timeout                int                      `yaml:"timeout"`
+	JWTSecret              string                   `yaml:"jwt_secret"`
+	Sensors                SensorsConfig            `yaml:"sensors"`
+	Processes              ProcessesConfig          `yaml:"processes"`
+	Backlight              BacklightConfig          `yaml:"backlight"`
+	Notifications          NotificationsConfig      `yaml:"notifications"`
+	Clipboard              ClipboardConfig          `yaml:"clipboard"`
+	Commands               map[string]CommandConfig `yaml:"commands"`
+	Files                  FilesConfig              `yaml:"files"`
+	History                HistoryConfig            `yaml:"history"`
+	Metrics                MetricsConfig            `yaml:"metrics"`
+	Alerts                 []AlertRule              `yaml:"alerts"`
+	Webhooks               []WebhookConfig          `yaml:"webhooks"`
+	MQTT                   MQTTConfig               `yaml:"mqtt"`
+}
+
+// SFTPConfig controls the SFTP server listening on BindAddress:BindPort.
+// The host key is generated on first start when the file does not exist.
+type SFTPConfig struct {
+	Disable bool   `yaml:"disable"`
+	HostKey string `yaml:"host_key"`
+}
+
+type SensorsConfig struct {
+	PrimaryCPUTemp string `yaml:"primary_cpu_temp"`
+}
+
+type ProcessesConfig struct {
+	TopCount int `yaml:"top_count"`
+}
+
+type BacklightConfig struct {
+	DDC bool `yaml:"ddc"`
+}
+
+type NotificationsConfig struct {
+	DisableMirror bool `yaml:"disable_mirror"`
+}
+
+type ClipboardConfig struct {
+	Disable bool `yaml:"disable"`
+}
+
+// FilesConfig names the directories exposed by the file API, e.g.
+// {"home": "/home/duke"}. Nothing outside of them can be reached.
+type FilesConfig struct {
+	Roots map[string]string `yaml:"roots"`
+}
+
+// HistoryConfig sets how long each tier (1s, 1m and 1h resolution) of the
+// metrics history is kept, in seconds. It is stored in DataPath("history").
+type HistoryConfig struct {
+	Disable         bool `yaml:"disable"`
+	SecondRetention int  `yaml:"second_retention"`
+	MinuteRetention int  `yaml:"minute_retention"`
+	HourRetention   int  `yaml:"hour_retention"`
+}
+
+// MetricsConfig enables the Prometheus endpoint at /metrics. Scrapers need
+// the bearer token or an address in AllowedIPs (addresses or CIDRs); with
+// neither set only loopback is allowed.
+type MetricsConfig struct {
+	Enable     bool     `yaml:"enable"`
+	Token      string   `yaml:"token"`
+	AllowedIPs []string `yaml:"allowed_ips"`
+}
+
+// MQTTConfig connects to a broker and publishes the stats under TopicPrefix
+// (nex/<hostname> by default), with Home Assistant discovery under
+// DiscoveryPrefix. Command topics are only subscribed when Scopes grants
+// something, power buttons need admin.
+type MQTTConfig struct {
+	Enable          bool     `yaml:"enable"`
+	Broker          string   `yaml:"broker"`
+	ClientID        string   `yaml:"client_id"`
+	Username        string   `yaml:"username"`
+	Password        string   `yaml:"password"`
+	TopicPrefix     string   `yaml:"topic_prefix"`
+	DiscoveryPrefix string   `yaml:"discovery_prefix"`
+	Interval        int      `yaml:"interval"`
+	Scopes          []string `yaml:"scopes"`
+}
+
+// AlertRule fires when Metric compared to Threshold holds for Duration
+// seconds, and resolves once the value is back past the threshold by more
+// than Hysteresis. Comparison is one of >, >=, < and <=.
+type AlertRule struct {
+	Name       string  `yaml:"name"`
+	Metric     string  `yaml:"metric"`
+	Comparison string  `yaml:"comparison"`
+	Threshold  float64 `yaml:"threshold"`
+	Duration   int     `yaml:"duration"`
+	Hysteresis float64 `yaml:"hysteresis"`
+	Severity   string  `yaml:"severity"`
+	Notify     bool    `yaml:"notify"`
+}
+
+// WebhookConfig posts the events matching Events (glob patterns such as
+// "alert*", "*" for everything) to URL. Template is a text/template
+// rendering the JSON body, Secret signs it with HMAC-SHA256 and failed
+// deliveries are retried with exponential backoff up to Retries times.
+type WebhookConfig struct {
+	URL      string            `yaml:"url"`
+	Events   []string          `yaml:"events"`
+	Template string            `yaml:"template"`
+	Secret   string            `yaml:"secret"`
+	Headers  map[string]string `yaml:"headers"`
+	Retries  int               `yaml:"retries"`
+}
+
+// CommandConfig is a named macro clients can run with run-command. Argv is
+// executed directly, never through a shell. User defaults to the desktop
+// user, Timeout (seconds) to the global timeout and Scopes to admin.
+type CommandConfig struct {
+	Description string            `yaml:"description"`
+	Argv        []string          `yaml:"argv"`
+	Dir         string            `yaml:"dir"`
+	Env         map[string]string `yaml:"env"`
+	User        string            `yaml:"user"`
+	Timeout     int               `yaml:"timeout"`
+	Scopes      []string          `yaml:"scopes"`
+	AllowArgs   bool              `yaml:"allow_args"`
 }
 
 var Current *Config
@@ -61,6 +184,16 @@ func Load() error {
 	return yaml.Unmarshal(data, Current)
 }
 
+// DataPath returns a directory below System.DataDirectory, the one place
+// for state kept across restarts.
+func DataPath(name string) string {
+	dir := Current.System.DataDirectory
+	if dir == "" {
+		dir = "/var/lib/nexserver"
+	}
+	return filepath.Join(dir, name)
+}
+
 func createDefault(path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -84,31 +217,40 @@ func createDefault(path string) error {
 			UploadLimit:           4064,
 		},
 		User: struct {
-			Username string `yaml:"username"`
-			Password string `yaml:"password"`
+			Username       string   `yaml:"username"`
+			Password       string   `yaml:"password"`
+			Scopes         []string `yaml:"scopes"`
+			AuthorizedKeys []string `yaml:"authorized_keys"`
 		}{
 			Username: "admin",
 			Password: "admin",
+			Scopes:   []string{"admin"},
 		},
 		System: struct {
-			LogDirectory           string `yaml:"log_directory"`
-			TmpDirectory           string `yaml:"tmp_directory"`
-			Timezone               string `yaml:"timezone"`
-			DiskCheckInterval      int    `yaml:"disk_check_interval"`
-			ActivitySendInterval   int    `yaml:"activity_send_interval"`
-			CheckPermissionsOnBoot bool   `yaml:"check_permissions_on_boot"`
-			EnableLogRotate        bool   `yaml:"enable_log_rotate"`
-			WebsocketLogCount      int    `yaml:"websocket_log_count"`
-			SFTP                   struct{}
+			LogDirectory           string     `yaml:"log_directory"`
+			TmpDirectory           string     `yaml:"tmp_directory"`
+			DataDirectory          string     `yaml:"data_directory"`
+			Timezone               string     `yaml:"timezone"`
+			DiskCheckInterval      int        `yaml:"disk_check_interval"`
+			ActivitySendInterval   int        `yaml:"activity_send_interval"`
+			CheckPermissionsOnBoot bool       `yaml:"check_permissions_on_boot"`
+			EnableLogRotate        bool       `yaml:"enable_log_rotate"`
+			WebsocketLogCount      int        `yaml:"websocket_log_count"`
+			SFTP                   SFTPConfig `yaml:"sftp"`
 		}{
 			LogDirectory:           "/var/log/nexserver",
 			TmpDirectory:           "/tmp/nexserver",
+			DataDirectory:          "/var/lib/nexserver",
 			Timezone:               "America/Sao_Paulo",
 			DiskCheckInterval:      150,
 			ActivitySendInterval:   60,
 			CheckPermissionsOnBoot: true,
 			EnableLogRotate:        true,
 			WebsocketLogCount:      150,
+			SFTP: SFTPConfig{
+				Disable: false,
+				HostKey: "/etc/nex/ssh_host_ed25519_key",
+			},
 		},
 		BindAddress:            "0.0.0.0",
 		BindPort:               2222,
@@ -117,6 +259,49 @@ func createDefault(path string) error {
 		DetectCleanExitAsCrash: true,
 		Timeout:                60,
 		JWTSecret:              jwtSecret,
+		Sensors: SensorsConfig{
+			PrimaryCPUTemp: "package",
+		},
+		Processes: ProcessesConfig{
+			TopCount: 5,
+		},
+		Backlight: BacklightConfig{
+			DDC: false,
+		},
+		Notifications: NotificationsConfig{
+			DisableMirror: false,
+		},
+		Clipboard: ClipboardConfig{
+			Disable: false,
+		},
+		Commands: map[string]CommandConfig{},
+		Files: FilesConfig{
+			Roots: map[string]string{},
+		},
+		History: HistoryConfig{
+			Disable:         false,
+			SecondRetention: 3600,
+			MinuteRetention: 604800,
+			HourRetention:   31536000,
+		},
+		Metrics: MetricsConfig{
+			Enable:     false,
+			Token:      "",
+			AllowedIPs: []string{},
+		},
+		Alerts: []AlertRule{
+			{Name: "CPU temperature", Metric: "cpu_temp", Comparison: ">", Threshold: 90, Duration: 30, Hysteresis: 5, Severity: "critical", Notify: true},
+			{Name: "Battery low", Metric: "battery", Comparison: "<", Threshold: 15, Duration: 0, Hysteresis: 2, Severity: "warning", Notify: true},
+			{Name: "Disk almost full", Metric: "disk_percent", Comparison: ">", Threshold: 95, Duration: 60, Hysteresis: 1, Severity: "warning", Notify: true},
+		},
+		Webhooks: []WebhookConfig{},
+		MQTT: MQTTConfig{
+			Enable:          false,
+			Broker:          "tcp://localhost:1883",
+			DiscoveryPrefix: "homeassistant",
+			Interval:        10,
+			Scopes:          []string{},
+		},
 	}
 
 	data, err := yaml.Marshal(cfg)
@@ -0,0 +1,305 @@
+package history
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	slotSize  = 32
+	maxPoints = 2000
+
+	defaultSecondRetention = 3600
+	defaultMinuteRetention = 7 * 24 * 3600
+	defaultHourRetention   = 365 * 24 * 3600
+)
+
+// Metrics are the values recorded from every stats sample. Network values
+// are rates in bytes per second, the others are taken as reported.
+var Metrics = []string{
+	"cpu", "cpu_temp", "memory", "swap", "disk",
+	"network_rx", "network_tx", "battery", "volume", "backlight",
+}
+
+var ErrUnknownMetric = errors.New("unknown metric")
+
+// Store keeps every metric in three round-robin files with 1s, 1m and 1h
+// resolution, like rrdtool. A file holds a fixed number of 32 byte slots
+// (timestamp, average, min, max) and the slot of a point is its timestamp
+// divided by the step, modulo the slot count, so old points are overwritten
+// once the retention has passed and the files never grow.
+type Store struct {
+	mu     sync.Mutex
+	series map[string]*series
+	last   int64
+
+	lastRx, lastTx uint64
+	lastNet        int64
+}
+
+type series struct {
+	tiers []*tier
+}
+
+type tier struct {
+	step  int64
+	slots int64
+	file  *os.File
+	acc   accumulator
+}
+
+// accumulator aggregates the 1s samples falling into the current slot of a
+// coarser tier. The partial result is written on every sample, so queries
+// include the current minute and hour.
+type accumulator struct {
+	start         int64
+	sum, min, max float64
+	count         int
+}
+
+func Open() (*Store, error) {
+	cfg := config.Current.History
+	dir := config.DataPath("history")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	retention := []int64{
+		positive(cfg.SecondRetention, defaultSecondRetention),
+		positive(cfg.MinuteRetention, defaultMinuteRetention),
+		positive(cfg.HourRetention, defaultHourRetention),
+	}
+	steps := []int64{1, 60, 3600}
+	names := []string{"1s", "1m", "1h"}
+
+	s := &Store{series: make(map[string]*series)}
+	for _, metric := range Metrics {
+		ser := &series{}
+		for i, step := range steps {
+			slots := retention[i] / step
+			if slots < 1 {
+				slots = 1
+			}
+			file, err := openTierFile(filepath.Join(dir, fmt.Sprintf("%s.%s.rrd", metric, names[i])), slots)
+			if err != nil {
+				return nil, err
+			}
+			ser.tiers = append(ser.tiers, &tier{step: step, slots: slots, file: file})
+		}
+		s.series[metric] = ser
+	}
+	return s, nil
+}
+
+// openTierFile resizes the file when the retention changed. Points left in
+// the wrong slot are ignored when reading.
+func openTierFile(path string, slots int64) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.Size() != slots*slotSize {
+		if err := file.Truncate(slots * slotSize); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func positive(value int, fallback int64) int64 {
+	if value <= 0 {
+		return fallback
+	}
+	return int64(value)
+}
+
+// Record stores one stats sample. Samples within the same second as the
+// previous one are dropped.
+func (s *Store) Record(stats models.SystemStats) {
+	now := time.Now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now == s.last {
+		return
+	}
+	s.last = now
+
+	values := map[string]float64{
+		"cpu":    stats.CpuAbsolute,
+		"memory": float64(stats.MemoryBytes),
+		"swap":   float64(stats.SwapBytes),
+		"disk":   float64(stats.DiskBytes),
+		"volume": float64(stats.Volume),
+	}
+	if stats.CpuTemp > 0 {
+		values["cpu_temp"] = stats.CpuTemp
+	}
+	if stats.Battery.Present {
+		values["battery"] = float64(stats.Battery.Percentage)
+	}
+	if len(stats.Backlights) > 0 {
+		values["backlight"] = float64(stats.Backlight)
+	}
+
+	rx, tx := stats.Network.RxBytes, stats.Network.TxBytes
+	if s.lastNet > 0 && rx >= s.lastRx && tx >= s.lastTx {
+		elapsed := float64(now - s.lastNet)
+		values["network_rx"] = float64(rx-s.lastRx) / elapsed
+		values["network_tx"] = float64(tx-s.lastTx) / elapsed
+	}
+	s.lastRx, s.lastTx, s.lastNet = rx, tx, now
+
+	for metric, value := range values {
+		for _, t := range s.series[metric].tiers {
+			t.add(now, value)
+		}
+	}
+}
+
+func (t *tier) add(now int64, value float64) {
+	start := now - now%t.step
+	if t.acc.start != start || t.acc.count == 0 {
+		t.acc = accumulator{start: start, min: value, max: value}
+	}
+	t.acc.sum += value
+	t.acc.count++
+	t.acc.min = math.Min(t.acc.min, value)
+	t.acc.max = math.Max(t.acc.max, value)
+
+	t.write(models.HistoryPoint{
+		Time: start,
+		Avg:  t.acc.sum / float64(t.acc.count),
+		Min:  t.acc.min,
+		Max:  t.acc.max,
+	})
+}
+
+func (t *tier) write(point models.HistoryPoint) {
+	buf := make([]byte, slotSize)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(point.Time))
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(point.Avg))
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(point.Min))
+	binary.LittleEndian.PutUint64(buf[24:], math.Float64bits(point.Max))
+	t.file.WriteAt(buf, (point.Time/t.step)%t.slots*slotSize)
+}
+
+func (t *tier) read(from, to int64) ([]models.HistoryPoint, error) {
+	data := make([]byte, t.slots*slotSize)
+	if _, err := t.file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	points := []models.HistoryPoint{}
+	for slot := int64(0); slot < t.slots; slot++ {
+		buf := data[slot*slotSize : (slot+1)*slotSize]
+		ts := int64(binary.LittleEndian.Uint64(buf[0:]))
+		if ts == 0 || ts < from || ts > to || (ts/t.step)%t.slots != slot {
+			continue
+		}
+		points = append(points, models.HistoryPoint{
+			Time: ts,
+			Avg:  math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+			Min:  math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+			Max:  math.Float64frombits(binary.LittleEndian.Uint64(buf[24:])),
+		})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time < points[j].Time
+	})
+	return points, nil
+}
+
+// Query returns metric between from and to (unix seconds, to defaults to
+// now and from to one hour before). It reads the coarsest tier that still
+// covers from and is not coarser than step, then merges points into step
+// sized buckets. step is raised when the range would exceed 2000 points.
+func (s *Store) Query(metric string, from, to, step int64) (models.History, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ser, ok := s.series[metric]
+	if !ok {
+		return models.History{}, ErrUnknownMetric
+	}
+
+	now := time.Now().Unix()
+	if to <= 0 {
+		to = now
+	}
+	if from <= 0 {
+		from = to - 3600
+	}
+	if from >= to {
+		return models.History{}, errors.New("from must be before to")
+	}
+
+	// The finest tier still holding from, or a coarser one when it is not
+	// coarser than the requested step.
+	index := len(ser.tiers) - 1
+	for i, t := range ser.tiers {
+		if now-t.slots*t.step <= from {
+			index = i
+			break
+		}
+	}
+	for index+1 < len(ser.tiers) && ser.tiers[index+1].step <= step {
+		index++
+	}
+	chosen := ser.tiers[index]
+
+	if step < chosen.step {
+		step = chosen.step
+	}
+	if (to-from)/step > maxPoints {
+		step = (to - from + maxPoints - 1) / maxPoints
+		step += (chosen.step - step%chosen.step) % chosen.step
+	}
+
+	points, err := chosen.read(from, to)
+	if err != nil {
+		return models.History{}, err
+	}
+	if step > chosen.step {
+		points = downsample(points, step)
+	}
+
+	return models.History{Metric: metric, From: from, To: to, Step: step, Points: points}, nil
+}
+
+func downsample(points []models.HistoryPoint, step int64) []models.HistoryPoint {
+	merged := []models.HistoryPoint{}
+	count := 0
+	for _, point := range points {
+		start := point.Time - point.Time%step
+		last := len(merged) - 1
+		if last < 0 || merged[last].Time != start {
+			if last >= 0 {
+				merged[last].Avg /= float64(count)
+			}
+			merged = append(merged, models.HistoryPoint{Time: start, Avg: point.Avg, Min: point.Min, Max: point.Max})
+			count = 1
+			continue
+		}
+		merged[last].Avg += point.Avg
+		merged[last].Min = math.Min(merged[last].Min, point.Min)
+		merged[last].Max = math.Max(merged[last].Max, point.Max)
+		count++
+	}
+	if len(merged) > 0 {
+		merged[len(merged)-1].Avg /= float64(count)
+	}
+	return merged
+}
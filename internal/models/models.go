@@ -6,23 +6,29 @@ type StatsEvent struct {
 }
 
 type SystemStats struct {
-	MemoryBytes      uint64       `json:"memory_bytes"`
-	MemoryLimitBytes uint64       `json:"memory_limit_bytes"`
-	SwapBytes        uint64       `json:"swap_bytes"`
-	SwapLimitBytes   uint64       `json:"swap_limit_bytes"`
-	CpuAbsolute      float64      `json:"cpu_absolute"`
-	CpuTemp          float64      `json:"cpu_temp"`
-	Network          NetworkStats `json:"network"`
-	Uptime           uint64       `json:"uptime"`
-	State            string       `json:"state"`
-	DiskBytes        uint64       `json:"disk_bytes"`
-	DiskTotal        uint64       `json:"disk_total"`
-	Audio            []AudioState `json:"audio"`
-	Wifi             WifiState    `json:"wifi"`
-	IP               string       `json:"ip"`
-	Battery          BatteryState `json:"battery"`
-	Volume           int          `json:"volume"`
-	Backlight        int          `json:"backlight"`
+	MemoryBytes      uint64            `json:"memory_bytes"`
+	MemoryLimitBytes uint64            `json:"memory_limit_bytes"`
+	SwapBytes        uint64            `json:"swap_bytes"`
+	SwapLimitBytes   uint64            `json:"swap_limit_bytes"`
+	CpuAbsolute      float64           `json:"cpu_absolute"`
+	CpuTemp          float64           `json:"cpu_temp"`
+	Sensors          []SensorChip      `json:"sensors"`
+	Network          NetworkStats      `json:"network"`
+	Uptime           uint64            `json:"uptime"`
+	State            string            `json:"state"`
+	DiskBytes        uint64            `json:"disk_bytes"`
+	DiskTotal        uint64            `json:"disk_total"`
+	Audio            []AudioState      `json:"audio"`
+	Wifi             WifiState         `json:"wifi"`
+	IP               string            `json:"ip"`
+	Battery          BatteryState      `json:"battery"`
+	Volume           int               `json:"volume"`
+	Muted            bool              `json:"muted"`
+	Streams          []AudioStream     `json:"streams"`
+	Backlight        int               `json:"backlight"`
+	Backlights       []BacklightDevice `json:"backlights"`
+	Processes        ProcessStats      `json:"processes"`
+	Power            PowerState        `json:"power"`
 }
 
 type NetworkStats struct {
@@ -30,6 +36,51 @@ type NetworkStats struct {
 	TxBytes uint64 `json:"tx_bytes"`
 }
 
+type SensorChip struct {
+	Name         string              `json:"name"`
+	Device       string              `json:"device"`
+	Temperatures []TemperatureSensor `json:"temperatures"`
+	Fans         []FanSensor         `json:"fans"`
+	Voltages     []VoltageSensor     `json:"voltages"`
+}
+
+type TemperatureSensor struct {
+	Label    string  `json:"label"`
+	Current  float64 `json:"current"`
+	High     float64 `json:"high"`
+	Critical float64 `json:"critical"`
+}
+
+type FanSensor struct {
+	Label string `json:"label"`
+	RPM   int    `json:"rpm"`
+	Min   int    `json:"min"`
+	Max   int    `json:"max"`
+}
+
+type VoltageSensor struct {
+	Label string  `json:"label"`
+	Volts float64 `json:"volts"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+type ProcessStats struct {
+	TopCPU    []ProcessInfo `json:"top_cpu"`
+	TopMemory []ProcessInfo `json:"top_memory"`
+}
+
+type ProcessInfo struct {
+	PID      int32   `json:"pid"`
+	Name     string  `json:"name"`
+	User     string  `json:"user"`
+	Cmdline  string  `json:"cmdline"`
+	CPU      float64 `json:"cpu"`
+	RSSBytes uint64  `json:"rss_bytes"`
+	Threads  int32   `json:"threads"`
+	State    string  `json:"state"`
+}
+
 type AudioState struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
@@ -43,13 +94,366 @@ type AudioState struct {
 }
 
 type WifiState struct {
+	SSID             string     `json:"ssid"`
+	Connected        bool       `json:"connected"`
+	Interface        string     `json:"interface"`
+	BSSID            string     `json:"bssid"`
+	Strength         int        `json:"strength"`
+	Frequency        int        `json:"frequency"`
+	Band             string     `json:"band"`
+	Bitrate          int        `json:"bitrate"`
+	Security         string     `json:"security"`
+	ActiveConnection string     `json:"active_connection"`
+	RadioEnabled     bool       `json:"radio_enabled"`
+	VPN              []VPNState `json:"vpn"`
+}
+
+type VPNState struct {
+	ID    string `json:"id"`
+	UUID  string `json:"uuid"`
+	State string `json:"state"`
+}
+
+type AccessPoint struct {
 	SSID      string `json:"ssid"`
-	Connected bool   `json:"connected"`
+	BSSID     string `json:"bssid"`
+	Strength  int    `json:"strength"`
+	Frequency int    `json:"frequency"`
+	Band      string `json:"band"`
+	Security  string `json:"security"`
+	Active    bool   `json:"active"`
+}
+
+type SavedConnection struct {
+	ID   string `json:"id"`
+	UUID string `json:"uuid"`
+	Type string `json:"type"`
+}
+
+type WifiNetworks struct {
+	AccessPoints []AccessPoint     `json:"access_points"`
+	Connections  []SavedConnection `json:"connections"`
 }
 
 type BatteryState struct {
-	Percentage int  `json:"percentage"`
+	Present     bool            `json:"present"`
+	State       string          `json:"state"`
+	Percentage  int             `json:"percentage"`
+	PluggedIn   bool            `json:"plugged_in"`
+	EnergyRate  float64         `json:"energy_rate"`
+	TimeToEmpty int64           `json:"time_to_empty"`
+	TimeToFull  int64           `json:"time_to_full"`
+	Batteries   []BatteryDevice `json:"batteries"`
+	Peripherals []BatteryDevice `json:"peripherals"`
+}
+
+type BatteryDevice struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	Vendor      string  `json:"vendor"`
+	Model       string  `json:"model"`
+	Present     bool    `json:"present"`
+	State       string  `json:"state"`
+	Percentage  float64 `json:"percentage"`
+	EnergyRate  float64 `json:"energy_rate"`
+	TimeToEmpty int64   `json:"time_to_empty"`
+	TimeToFull  int64   `json:"time_to_full"`
+	Capacity    float64 `json:"capacity"`
+	CycleCount  int32   `json:"cycle_count"`
+}
+
+type AudioDevice struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Volume      int    `json:"volume"`
+	Muted       bool   `json:"muted"`
+	Default     bool   `json:"default"`
+}
+
+type AudioDevices struct {
+	Sinks   []AudioDevice `json:"sinks"`
+	Sources []AudioDevice `json:"sources"`
+}
+
+type AudioStream struct {
+	Index       int    `json:"index"`
+	Application string `json:"application"`
+	IconName    string `json:"icon_name"`
+	MediaTitle  string `json:"media_title"`
+	Volume      int    `json:"volume"`
+	Muted       bool   `json:"muted"`
+	Sink        string `json:"sink"`
+	PlayerID    string `json:"player_id"`
+}
+
+type BacklightDevice struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Brightness    int    `json:"brightness"`
+	MaxBrightness int    `json:"max_brightness"`
+	Percentage    int    `json:"percentage"`
+}
+
+type PowerState struct {
+	Capabilities map[string]string   `json:"capabilities"`
+	Pending      *PendingPowerAction `json:"pending"`
+	KeepAwake    KeepAwakeState      `json:"keep_awake"`
+	Inhibitors   []Inhibitor         `json:"inhibitors"`
+}
+
+type KeepAwakeState struct {
+	Active bool  `json:"active"`
+	Until  int64 `json:"until"`
+}
+
+type Inhibitor struct {
+	What string `json:"what"`
+	Who  string `json:"who"`
+	Why  string `json:"why"`
+	Mode string `json:"mode"`
+	UID  uint32 `json:"uid"`
+	PID  uint32 `json:"pid"`
+}
+
+type PendingPowerAction struct {
+	Action    string `json:"action"`
+	ExecuteAt int64  `json:"execute_at"`
+}
+
+type PowerRequest struct {
+	Delay int `json:"delay"`
+}
+
+type SeekRequest struct {
+	Position int64 `json:"position"`
+}
+
+// VolumeRequest sets the volume or, with step, changes it relatively.
+type VolumeRequest struct {
+	Volume *int `json:"volume"`
+	Step   *int `json:"step"`
+}
+
+type MuteRequest struct {
+	Mode string `json:"mode"`
+}
+
+type DeviceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type StreamRequest struct {
+	Volume *int   `json:"volume"`
+	Mute   string `json:"mute"`
+	Sink   string `json:"sink"`
+}
+
+type BrightnessRequest struct {
+	Percent *int `json:"percent"`
+}
+
+type ActionRequest struct {
+	Args []string `json:"args"`
+}
+
+type Notification struct {
+	ID        uint32               `json:"id"`
+	App       string               `json:"app"`
+	Summary   string               `json:"summary"`
+	Body      string               `json:"body"`
+	Icon      string               `json:"icon"`
+	Urgency   string               `json:"urgency"`
+	Actions   []NotificationAction `json:"actions"`
+	Timestamp int64                `json:"timestamp"`
+}
+
+type NotificationAction struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+type NotificationClosed struct {
+	ID     uint32 `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type ClipboardContent struct {
+	Type      string `json:"type"`
+	Mime      string `json:"mime"`
+	Text      string `json:"text,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated"`
+}
+
+type ScreenshotRequest struct {
+	Monitor string `form:"monitor" json:"monitor"`
+	Width   int    `form:"width" json:"width"`
+	Format  string `form:"format" json:"format"`
+	Quality int    `form:"quality" json:"quality"`
+}
+
+type Screenshot struct {
+	Mime string `json:"mime"`
+	Data string `json:"data"`
+}
+
+type Application struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Comment    string   `json:"comment,omitempty"`
+	Icon       string   `json:"icon"`
+	Categories []string `json:"categories"`
+}
+
+type Window struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	AppID     string `json:"app_id"`
+	PID       int    `json:"pid"`
+	Workspace string `json:"workspace"`
+	Focused   bool   `json:"focused"`
+}
+
+type CommandInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AllowArgs   bool   `json:"allow_args"`
+}
+
+type CommandRun struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CommandOutput struct {
+	ID     string `json:"id"`
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+}
+
+type CommandExit struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Code     int    `json:"code"`
+	TimedOut bool   `json:"timed_out"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration"`
+}
+
+type FileEntry struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Type     string      `json:"type"`
+	Size     int64       `json:"size"`
+	Mode     string      `json:"mode"`
+	Modified int64       `json:"modified"`
+	Entries  []FileEntry `json:"entries,omitempty"`
+}
+
+type FileRename struct {
+	To string `json:"to"`
+}
+
+type UploadStatus struct {
+	Offset   int64 `json:"offset"`
+	Complete bool  `json:"complete"`
+}
+
+type SFTPActivity struct {
+	User   string `json:"user"`
+	Remote string `json:"remote"`
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+	To     string `json:"to,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+}
+
+type HistoryPoint struct {
+	Time int64   `json:"time"`
+	Avg  float64 `json:"avg"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+type History struct {
+	Metric string         `json:"metric"`
+	From   int64          `json:"from"`
+	To     int64          `json:"to"`
+	Step   int64          `json:"step"`
+	Points []HistoryPoint `json:"points"`
+}
+
+type HistoryRequest struct {
+	Metric string `form:"metric"`
+	From   int64  `form:"from"`
+	To     int64  `form:"to"`
+	Step   int64  `form:"step"`
+}
+
+type ServerStats struct {
+	Clients          int               `json:"clients"`
+	Broadcasts       uint64            `json:"broadcasts"`
+	BroadcastSeconds float64           `json:"broadcast_seconds"`
+	CollectorErrors  map[string]uint64 `json:"collector_errors"`
+}
+
+type Alert struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Metric     string  `json:"metric"`
+	Comparison string  `json:"comparison"`
+	Threshold  float64 `json:"threshold"`
+	Value      float64 `json:"value"`
+	Severity   string  `json:"severity"`
+	State      string  `json:"state"`
+	StartedAt  int64   `json:"started_at"`
+	FiredAt    int64   `json:"fired_at"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+}
+
+type AlertList struct {
+	Active []Alert `json:"active"`
+	Recent []Alert `json:"recent"`
+}
+
+type PowerSource struct {
 	PluggedIn  bool `json:"plugged_in"`
+	Percentage int  `json:"percentage"`
+}
+
+// Error codes of APIError. Clients match on the code, the message is for
+// people and may change.
+const (
+	ErrInvalidRequest     = "invalid_request"
+	ErrInvalidArguments   = "invalid_arguments"
+	ErrInvalidCredentials = "invalid_credentials"
+	ErrUnauthorized       = "unauthorized"
+	ErrForbidden          = "forbidden"
+	ErrReadOnly           = "read_only"
+	ErrNotFound           = "not_found"
+	ErrUnknownEvent       = "unknown_event"
+	ErrConflict           = "conflict"
+	ErrOffsetMismatch     = "offset_mismatch"
+	ErrPayloadTooLarge    = "payload_too_large"
+	ErrUnavailable        = "unavailable"
+	ErrNotImplemented     = "not_implemented"
+	ErrInternal           = "internal_error"
+)
+
+// APIError is the error of every failed REST request, wrapped in
+// ErrorResponse, and the payload of the websocket error event.
+type APIError struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
 }
 
 type LoginRequest struct {
@@ -0,0 +1,293 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"nex-server/internal/ws"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+var nodeUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Bridge publishes the stats of the manager to an MQTT broker and runs the
+// events received on the command topics through a local websocket client,
+// so MQTT gets exactly the behaviour and permissions of the socket.
+//
+// Topics, below the prefix:
+//
+//	availability          online / offline (last will)
+//	<sensor>              retained value of each sensor
+//	media/<field>         retained state of the active player
+//	command/<event>       websocket event, payload is one argument or a JSON array
+//	media/command/<cmd>   play, pause, playpause, next, previous, volume (0-1)
+//	reply                 replies of the events, as sent on the socket
+type Bridge struct {
+	manager   *ws.Manager
+	client    paho.Client
+	local     *ws.Client
+	commands  chan command
+	prefix    string
+	discovery string
+	node      string
+	hostname  string
+
+	mu        sync.Mutex
+	published map[string]string
+}
+
+type command struct {
+	event string
+	args  []string
+}
+
+func NewBridge(manager *ws.Manager) *Bridge {
+	cfg := config.Current.MQTT
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "nex-server"
+	}
+	node := nodeUnsafe.ReplaceAllString(hostname, "_")
+
+	b := &Bridge{
+		manager:   manager,
+		commands:  make(chan command, 16),
+		prefix:    strings.TrimSuffix(cfg.TopicPrefix, "/"),
+		discovery: strings.TrimSuffix(cfg.DiscoveryPrefix, "/"),
+		node:      node,
+		hostname:  hostname,
+		published: make(map[string]string),
+	}
+	if b.prefix == "" {
+		b.prefix = "nex/" + node
+	}
+	if b.discovery == "" {
+		b.discovery = "homeassistant"
+	}
+	if len(cfg.Scopes) > 0 {
+		b.local = manager.NewLocalClient(&auth.Claims{Username: "mqtt", Type: "mqtt", Scopes: cfg.Scopes})
+	}
+
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = "nex-" + node
+	}
+	broker := cfg.Broker
+	if broker == "" {
+		broker = "tcp://localhost:1883"
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetWill(b.topic("availability"), "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+	b.client = paho.NewClient(opts)
+	return b
+}
+
+func (b *Bridge) topic(name string) string {
+	return b.prefix + "/" + name
+}
+
+// Run connects, retrying in the background, and publishes the stats every
+// mqtt.interval seconds. Only values that changed are sent again.
+func (b *Bridge) Run() {
+	b.client.Connect()
+	log.Printf("Connecting to MQTT broker %s", config.Current.MQTT.Broker)
+
+	if b.local != nil {
+		go b.handleCommands()
+		go b.forwardReplies()
+	}
+
+	interval := config.Current.MQTT.Interval
+	if interval <= 0 {
+		interval = 10
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if stats, ok := b.manager.Snapshot(); ok && b.client.IsConnectionOpen() {
+			b.publishStats(stats)
+		}
+	}
+}
+
+// onConnect runs after every (re)connect. The broker may have lost the
+// retained messages, so everything is announced and published again.
+func (b *Bridge) onConnect(client paho.Client) {
+	log.Printf("Connected to MQTT broker")
+
+	b.mu.Lock()
+	b.published = make(map[string]string)
+	b.mu.Unlock()
+
+	client.Publish(b.topic("availability"), 1, true, "online")
+	b.announce()
+
+	if b.local != nil {
+		client.Subscribe(b.topic("command/+"), 1, b.onCommand)
+		client.Subscribe(b.topic("media/command/+"), 1, b.onMediaCommand)
+	}
+
+	if stats, ok := b.manager.Snapshot(); ok {
+		b.publishStats(stats)
+	}
+}
+
+func (b *Bridge) publish(topic, payload string) {
+	b.mu.Lock()
+	if b.published[topic] == payload {
+		b.mu.Unlock()
+		return
+	}
+	b.published[topic] = payload
+	b.mu.Unlock()
+
+	b.client.Publish(topic, 1, true, payload)
+}
+
+func (b *Bridge) publishStats(stats models.SystemStats) {
+	for _, s := range sensors {
+		if value, ok := s.value(stats); ok {
+			b.publish(b.topic(s.key), value)
+		}
+	}
+	if stats.Battery.Present {
+		b.publish(b.topic("plugged_in"), onOff(stats.Battery.PluggedIn))
+	}
+
+	player, ok := activePlayer(stats.Audio)
+	state := "idle"
+	if ok {
+		state = "paused"
+		if player.Playing {
+			state = "playing"
+		}
+	}
+	b.publish(b.topic("media/state"), state)
+	b.publish(b.topic("media/title"), player.Title)
+	b.publish(b.topic("media/artist"), player.Artist)
+	b.publish(b.topic("media/album"), player.Album)
+	b.publish(b.topic("media/duration"), strconv.FormatInt(player.Duration, 10))
+	b.publish(b.topic("media/position"), strconv.FormatInt(player.Timestamp, 10))
+	b.publish(b.topic("media/volume"), strconv.FormatFloat(float64(stats.Volume)/100, 'f', 2, 64))
+}
+
+// activePlayer is the first playing player, or the first one at all.
+func activePlayer(players []models.AudioState) (models.AudioState, bool) {
+	for _, player := range players {
+		if player.Playing {
+			return player, true
+		}
+	}
+	if len(players) > 0 {
+		return players[0], true
+	}
+	return models.AudioState{}, false
+}
+
+func (b *Bridge) onCommand(_ paho.Client, msg paho.Message) {
+	event := strings.TrimPrefix(msg.Topic(), b.topic("command/"))
+	b.queue(command{event: event, args: parseArgs(msg.Payload())})
+}
+
+// onMediaCommand maps the media player entity onto the websocket events.
+// play and pause go to the player shown in the media topics, so they do
+// not toggle it when it is already in that state.
+func (b *Bridge) onMediaCommand(_ paho.Client, msg paho.Message) {
+	switch action := strings.TrimPrefix(msg.Topic(), b.topic("media/command/")); action {
+	case "play", "pause":
+		stats, ok := b.manager.Snapshot()
+		if !ok {
+			return
+		}
+		if player, ok := activePlayer(stats.Audio); ok {
+			b.queue(command{event: "audio", args: []string{player.ID, action}})
+		}
+	case "playpause":
+		b.queue(command{event: "media", args: []string{"play_pause"}})
+	case "next":
+		b.queue(command{event: "media", args: []string{"next"}})
+	case "previous":
+		b.queue(command{event: "media", args: []string{"previous"}})
+	case "volume":
+		level, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload())), 64)
+		if err != nil {
+			return
+		}
+		b.queue(command{event: "volume-set", args: []string{strconv.Itoa(int(math.Round(level * 100)))}})
+	}
+}
+
+// queue hands a command to the worker, paho must not be blocked by a slow
+// handler.
+func (b *Bridge) queue(cmd command) {
+	select {
+	case b.commands <- cmd:
+	default:
+		log.Printf("MQTT command %s dropped, queue full", cmd.event)
+	}
+}
+
+func (b *Bridge) handleCommands() {
+	for cmd := range b.commands {
+		b.local.Handle(cmd.event, cmd.args)
+	}
+}
+
+func (b *Bridge) forwardReplies() {
+	for msg := range b.local.Send {
+		b.client.Publish(b.topic("reply"), 0, false, msg)
+	}
+}
+
+// parseArgs takes a JSON array of strings as the arguments, anything else
+// as the only argument.
+func parseArgs(payload []byte) []string {
+	text := strings.TrimSpace(string(payload))
+	if text == "" {
+		return nil
+	}
+	var args []string
+	if strings.HasPrefix(text, "[") && json.Unmarshal([]byte(text), &args) == nil {
+		return args
+	}
+	return []string{text}
+}
+
+func onOff(value bool) string {
+	if value {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func percentOf(used, total uint64) (string, bool) {
+	if total == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%.1f", float64(used)/float64(total)*100), true
+}
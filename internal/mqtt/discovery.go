@@ -0,0 +1,147 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"strconv"
+)
+
+type sensor struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+	value       func(models.SystemStats) (string, bool)
+}
+
+var sensors = []sensor{
+	{"cpu", "CPU usage", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return fmt.Sprintf("%.1f", s.CpuAbsolute), true
+	}},
+	{"cpu_temp", "CPU temperature", "°C", "temperature", "measurement", func(s models.SystemStats) (string, bool) {
+		return fmt.Sprintf("%.1f", s.CpuTemp), s.CpuTemp > 0
+	}},
+	{"memory", "Memory usage", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return percentOf(s.MemoryBytes, s.MemoryLimitBytes)
+	}},
+	{"swap", "Swap usage", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return percentOf(s.SwapBytes, s.SwapLimitBytes)
+	}},
+	{"disk", "Disk usage", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return percentOf(s.DiskBytes, s.DiskTotal)
+	}},
+	{"battery", "Battery", "%", "battery", "measurement", func(s models.SystemStats) (string, bool) {
+		return strconv.Itoa(s.Battery.Percentage), s.Battery.Present
+	}},
+	{"volume", "Volume", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return strconv.Itoa(s.Volume), true
+	}},
+	{"wifi_signal", "Wi-Fi signal", "%", "", "measurement", func(s models.SystemStats) (string, bool) {
+		return strconv.Itoa(s.Wifi.Strength), s.Wifi.Connected
+	}},
+	{"wifi_ssid", "Wi-Fi network", "", "", "", func(s models.SystemStats) (string, bool) {
+		return s.Wifi.SSID, s.Wifi.Connected
+	}},
+	{"uptime", "Uptime", "s", "duration", "", func(s models.SystemStats) (string, bool) {
+		return strconv.FormatInt(int64(s.Uptime), 10), true
+	}},
+	{"network_rx", "Network received", "B", "data_size", "total_increasing", func(s models.SystemStats) (string, bool) {
+		return strconv.FormatUint(s.Network.RxBytes, 10), true
+	}},
+	{"network_tx", "Network sent", "B", "data_size", "total_increasing", func(s models.SystemStats) (string, bool) {
+		return strconv.FormatUint(s.Network.TxBytes, 10), true
+	}},
+}
+
+var powerButtons = []struct {
+	action string
+	name   string
+	icon   string
+}{
+	{"lock", "Lock", "mdi:lock"},
+	{"suspend", "Suspend", "mdi:power-sleep"},
+	{"reboot", "Reboot", "mdi:restart"},
+	{"power-off", "Power off", "mdi:power"},
+}
+
+// announce publishes the Home Assistant discovery configs. Every entity
+// belongs to one device named after the host and goes unavailable with the
+// last will.
+func (b *Bridge) announce() {
+	device := map[string]interface{}{
+		"identifiers":  []string{"nex_" + b.node},
+		"name":         b.hostname,
+		"manufacturer": "nex-server",
+	}
+	entity := func(component, key, name string, extra map[string]interface{}) {
+		payload := map[string]interface{}{
+			"name":               name,
+			"unique_id":          "nex_" + b.node + "_" + key,
+			"availability_topic": b.topic("availability"),
+			"device":             device,
+		}
+		for k, v := range extra {
+			payload[k] = v
+		}
+		data, _ := json.Marshal(payload)
+		b.client.Publish(fmt.Sprintf("%s/%s/%s/%s/config", b.discovery, component, b.node, key), 1, true, data)
+	}
+
+	for _, s := range sensors {
+		extra := map[string]interface{}{"state_topic": b.topic(s.key)}
+		if s.unit != "" {
+			extra["unit_of_measurement"] = s.unit
+		}
+		if s.deviceClass != "" {
+			extra["device_class"] = s.deviceClass
+		}
+		if s.stateClass != "" {
+			extra["state_class"] = s.stateClass
+		}
+		entity("sensor", s.key, s.name, extra)
+	}
+	entity("binary_sensor", "plugged_in", "AC power", map[string]interface{}{
+		"state_topic":  b.topic("plugged_in"),
+		"device_class": "plug",
+	})
+
+	// media_player is not part of the core MQTT integration, this follows
+	// the schema of the mqtt_media_player custom integration.
+	media := map[string]interface{}{
+		"state_state_topic":    b.topic("media/state"),
+		"state_title_topic":    b.topic("media/title"),
+		"state_artist_topic":   b.topic("media/artist"),
+		"state_album_topic":    b.topic("media/album"),
+		"state_duration_topic": b.topic("media/duration"),
+		"state_position_topic": b.topic("media/position"),
+		"state_volume_topic":   b.topic("media/volume"),
+	}
+	if b.local != nil {
+		for _, cmd := range []string{"play", "pause", "playpause", "next", "previous", "volume"} {
+			media["command_"+cmd+"_topic"] = b.topic("media/command/" + cmd)
+			if cmd != "volume" {
+				media["command_"+cmd+"_payload"] = cmd
+			}
+		}
+	}
+	entity("media_player", "media", "Media", media)
+
+	admin := b.local != nil && b.local.Claims.HasScope(auth.ScopeAdmin) && !config.Current.ReadOnly
+	for _, button := range powerButtons {
+		key := "power_" + button.action
+		if !admin {
+			// An empty retained config removes a button announced before.
+			b.client.Publish(fmt.Sprintf("%s/button/%s/%s/config", b.discovery, b.node, key), 1, true, "")
+			continue
+		}
+		entity("button", key, button.name, map[string]interface{}{
+			"command_topic": b.topic("command/power"),
+			"payload_press": button.action,
+			"icon":          button.icon,
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package sftpd
+
+import (
+	"errors"
+	"io"
+	"nex-server/internal/config"
+	"nex-server/internal/system"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// handler maps the SFTP requests of a session onto the file roots. The
+// top level directory is virtual and lists one folder per root.
+type handler struct {
+	session *session
+}
+
+func (s *session) handlers() sftp.Handlers {
+	h := &handler{session: s}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+func (h *handler) resolve(p string) (string, error) {
+	root, rel := splitPath(p)
+	if root == "" {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	path, err := system.ResolvePath(root, rel)
+	return path, sftpError(err)
+}
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if config.Current.API.DisableRemoteDownload {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	path, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	return &trackedFile{File: f, session: h.session, action: "download", path: r.Filepath}, nil
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if config.Current.ReadOnly {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	path, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	// WriteAt is not allowed on files opened with O_APPEND, clients send
+	// the offsets anyway.
+	pflags := r.Pflags()
+	flags := os.O_WRONLY
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+
+	_, statErr := os.Lstat(path)
+	f, err := system.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	if os.IsNotExist(statErr) {
+		system.MatchOwner(path)
+	}
+	return &trackedFile{File: f, session: h.session, action: "upload", path: r.Filepath}, nil
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	if config.Current.ReadOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	root, rel := splitPath(r.Filepath)
+	if root == "" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Rename":
+		targetRoot, targetRel := splitPath(r.Target)
+		if targetRoot != root {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		if err := system.RenamePath(root, rel, targetRel); err != nil {
+			return sftpError(err)
+		}
+		h.session.activity("rename", r.Filepath, r.Target, 0)
+	case "Rmdir", "Remove":
+		if err := system.DeletePath(root, rel, false); err != nil {
+			return sftpError(err)
+		}
+		h.session.activity("delete", r.Filepath, "", 0)
+	case "Mkdir":
+		if err := system.MakeDirectory(root, rel); err != nil {
+			return sftpError(err)
+		}
+		h.session.activity("mkdir", r.Filepath, "", 0)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return nil
+}
+
+// setstat applies size, permission and time changes. Ownership changes
+// are ignored, files keep the owner of their directory.
+func (h *handler) setstat(r *sftp.Request) error {
+	path, err := h.resolve(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	attrs := r.Attributes()
+	flags := r.AttrFlags()
+	if flags.Size {
+		if err := os.Truncate(path, int64(attrs.Size)); err != nil {
+			return sftpError(err)
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(path, attrs.FileMode().Perm()); err != nil {
+			return sftpError(err)
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(path, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return sftpError(err)
+		}
+	}
+	return nil
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	root, rel := splitPath(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		if root == "" {
+			return rootList(), nil
+		}
+		path, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		infos := listerAt{}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	case "Stat":
+		if root == "" {
+			return listerAt{virtualDir{name: "/"}}, nil
+		}
+		path, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		if rel == "/" {
+			info = namedInfo{FileInfo: info, name: root}
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func rootList() listerAt {
+	infos := listerAt{}
+	for _, name := range system.FileRoots() {
+		path, err := system.ResolvePath(name, "/")
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			infos = append(infos, namedInfo{FileInfo: info, name: name})
+		}
+	}
+	return infos
+}
+
+func sftpError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, system.ErrUnknownRoot), os.IsNotExist(err):
+		return sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, system.ErrOutsideRoot), errors.Is(err, system.ErrRootPath), errors.Is(err, system.ErrNotRegular), os.IsPermission(err):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
+
+// trackedFile counts the bytes moved through a handle and reports the
+// transfer when the client closes it.
+type trackedFile struct {
+	*os.File
+	session *session
+	action  string
+	path    string
+	bytes   atomic.Int64
+}
+
+func (f *trackedFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.bytes.Add(int64(n))
+	return n, err
+}
+
+func (f *trackedFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.bytes.Add(int64(n))
+	return n, err
+}
+
+func (f *trackedFile) Close() error {
+	err := f.File.Close()
+	f.session.activity(f.action, f.path, "", f.bytes.Load())
+	return err
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
+
+type virtualDir struct {
+	name string
+}
+
+func (d virtualDir) Name() string       { return d.name }
+func (d virtualDir) Size() int64        { return 0 }
+func (d virtualDir) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d virtualDir) ModTime() time.Time { return time.Now() }
+func (d virtualDir) IsDir() bool        { return true }
+func (d virtualDir) Sys() interface{}   { return nil }
@@ -0,0 +1,202 @@
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultHostKey = "/etc/nex/ssh_host_ed25519_key"
+
+// Server is an SFTP-only SSH server exposing the file roots of the config.
+// Sessions authenticate as the nex-server user, with its password or one of
+// its authorized keys, and need the files scope.
+type Server struct {
+	emit   func(event string, payload interface{})
+	config *ssh.ServerConfig
+}
+
+func NewServer(emit func(event string, payload interface{})) (*Server, error) {
+	signer, err := loadHostKey()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{emit: emit}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  passwordAuth,
+		PublicKeyCallback: publicKeyAuth,
+	}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+func (s *Server) ListenAndServe() error {
+	addr := fmt.Sprintf("%s:%d", config.Current.BindAddress, config.Current.BindPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Starting SFTP server on %s", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	session := &session{
+		emit:   s.emit,
+		user:   sshConn.User(),
+		remote: sshConn.RemoteAddr().String(),
+	}
+	session.activity("connect", "", "", 0)
+	defer session.activity("disconnect", "", "", 0)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveChannel(channel, reqs, session)
+	}
+}
+
+// serveChannel only accepts the sftp subsystem, shells and exec requests
+// are refused.
+func (s *Server) serveChannel(channel ssh.Channel, reqs <-chan *ssh.Request, session *session) {
+	defer channel.Close()
+
+	for req := range reqs {
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		server := sftp.NewRequestServer(channel, session.handlers())
+		server.Serve()
+		server.Close()
+		return
+	}
+}
+
+func passwordAuth(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user := config.Current.User
+	if meta.User() != user.Username || !allowed() ||
+		subtle.ConstantTimeCompare(password, []byte(user.Password)) != 1 {
+		return nil, errors.New("invalid credentials")
+	}
+	return &ssh.Permissions{}, nil
+}
+
+func publicKeyAuth(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if meta.User() != config.Current.User.Username || !allowed() {
+		return nil, errors.New("invalid credentials")
+	}
+
+	marshaled := key.Marshal()
+	for _, line := range config.Current.User.AuthorizedKeys {
+		authorized, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(authorized.Marshal(), marshaled) == 1 {
+			return &ssh.Permissions{}, nil
+		}
+	}
+	return nil, errors.New("unknown public key")
+}
+
+func allowed() bool {
+	claims := auth.Claims{Scopes: config.Current.User.Scopes}
+	return claims.HasScope(auth.ScopeFiles)
+}
+
+// loadHostKey reads the configured host key, creating an ed25519 key the
+// first time so the fingerprint stays the same across restarts.
+func loadHostKey() (ssh.Signer, error) {
+	path := config.Current.System.SFTP.HostKey
+	if path == "" {
+		path = defaultHostKey
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "nex-server")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
+
+type session struct {
+	emit   func(event string, payload interface{})
+	user   string
+	remote string
+}
+
+func (s *session) activity(action, path, to string, bytes int64) {
+	s.emit("sftp-activity", models.SFTPActivity{
+		User:   s.user,
+		Remote: s.remote,
+		Action: action,
+		Path:   path,
+		To:     to,
+		Bytes:  bytes,
+	})
+}
+
+// splitPath turns a client path into a root name and the path inside it.
+// "/" is the virtual directory listing the roots.
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(filepath.Clean("/"+p), "/")
+	root, rel, _ := strings.Cut(p, "/")
+	return root, "/" + rel
+}
@@ -0,0 +1,300 @@
+package system
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// applicationDirs follows the XDG lookup order: the user's own entries
+// shadow the system ones with the same desktop file id.
+func applicationDirs() []string {
+	dirs := []string{}
+	if home := userHome(getRealUID()); home != "" {
+		dirs = append(dirs,
+			filepath.Join(home, ".local/share/applications"),
+			filepath.Join(home, ".local/share/flatpak/exports/share/applications"),
+		)
+	}
+	return append(dirs,
+		"/var/lib/flatpak/exports/share/applications",
+		"/usr/local/share/applications",
+		"/usr/share/applications",
+	)
+}
+
+func GetApplications() []models.Application {
+	seen := map[string]bool{}
+	apps := []models.Application{}
+
+	for _, dir := range applicationDirs() {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".desktop") {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(dir, path)
+			id := strings.ReplaceAll(rel, string(filepath.Separator), "-")
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+
+			app, ok := parseDesktopEntry(path)
+			if !ok {
+				return nil
+			}
+			app.ID = id
+			apps = append(apps, app)
+			return nil
+		})
+	}
+
+	sort.Slice(apps, func(i, j int) bool {
+		return strings.ToLower(apps[i].Name) < strings.ToLower(apps[j].Name)
+	})
+	return apps
+}
+
+func parseDesktopEntry(path string) (models.Application, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return models.Application{}, false
+	}
+	defer f.Close()
+
+	app := models.Application{Categories: []string{}}
+	entryType := ""
+	hidden := false
+	inEntry := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inEntry = line == "[Desktop Entry]"
+			continue
+		}
+		if !inEntry {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Type":
+			entryType = value
+		case "Name":
+			app.Name = value
+		case "Comment":
+			app.Comment = value
+		case "Icon":
+			app.Icon = value
+		case "Categories":
+			for _, category := range strings.Split(value, ";") {
+				if category != "" {
+					app.Categories = append(app.Categories, category)
+				}
+			}
+		case "NoDisplay", "Hidden":
+			if value == "true" {
+				hidden = true
+			}
+		}
+	}
+
+	return app, entryType == "Application" && app.Name != "" && !hidden
+}
+
+// LaunchApplication starts a desktop entry as a transient unit of the user's
+// systemd instance, so it inherits the session environment and outlives
+// the server. gtk-launch is used directly when systemd --user is missing.
+func LaunchApplication(id string) error {
+	if id == "" || strings.ContainsAny(id, "/\x00") || !strings.HasSuffix(id, ".desktop") {
+		return errors.New("invalid application id")
+	}
+
+	found := false
+	for _, app := range GetApplications() {
+		if app.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("unknown application")
+	}
+
+	name := strings.TrimSuffix(id, ".desktop")
+	cmd := userCommand("systemd-run", "--user", "--collect", "--quiet", "gtk-launch", name)
+	cmd.Env = append(cmd.Env, "DBUS_SESSION_BUS_ADDRESS="+sessionBusAddress())
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+
+	cmd = graphicalCommand(waylandDisplay() != "", "gtk-launch", name)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// GetWindows asks the compositor for its window list. Only Hyprland, sway
+// and X11 window managers (through wmctrl) expose one; GNOME and KDE on
+// Wayland return an error.
+func GetWindows() ([]models.Window, error) {
+	if windows, err := hyprlandWindows(); err == nil {
+		return windows, nil
+	}
+	if windows, err := swayWindows(); err == nil {
+		return windows, nil
+	}
+	if windows, err := x11Windows(); err == nil {
+		return windows, nil
+	}
+	return nil, errors.New("window list not available for this desktop")
+}
+
+func hyprlandWindows() ([]models.Window, error) {
+	instances, _ := filepath.Glob(fmt.Sprintf("/run/user/%d/hypr/*", getRealUID()))
+	if len(instances) == 0 {
+		return nil, errors.New("hyprland not running")
+	}
+
+	cmd := userCommand("hyprctl", "clients", "-j")
+	cmd.Env = append(cmd.Env, "HYPRLAND_INSTANCE_SIGNATURE="+filepath.Base(instances[0]))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []struct {
+		Address   string `json:"address"`
+		Class     string `json:"class"`
+		Title     string `json:"title"`
+		PID       int    `json:"pid"`
+		Workspace struct {
+			Name string `json:"name"`
+		} `json:"workspace"`
+		FocusHistoryID int `json:"focusHistoryID"`
+	}
+	if err := json.Unmarshal(out, &clients); err != nil {
+		return nil, err
+	}
+
+	windows := []models.Window{}
+	for _, client := range clients {
+		windows = append(windows, models.Window{
+			ID:        client.Address,
+			Title:     client.Title,
+			AppID:     client.Class,
+			PID:       client.PID,
+			Workspace: client.Workspace.Name,
+			Focused:   client.FocusHistoryID == 0,
+		})
+	}
+	return windows, nil
+}
+
+type swayNode struct {
+	ID      int64      `json:"id"`
+	Type    string     `json:"type"`
+	Name    string     `json:"name"`
+	AppID   string     `json:"app_id"`
+	PID     int        `json:"pid"`
+	Focused bool       `json:"focused"`
+	Nodes   []swayNode `json:"nodes"`
+	Floats  []swayNode `json:"floating_nodes"`
+	Window  *struct {
+		Class string `json:"class"`
+	} `json:"window_properties"`
+}
+
+func swayWindows() ([]models.Window, error) {
+	sockets, _ := filepath.Glob(fmt.Sprintf("/run/user/%d/sway-ipc.*.sock", getRealUID()))
+	if len(sockets) == 0 {
+		return nil, errors.New("sway not running")
+	}
+
+	cmd := userCommand("swaymsg", "-t", "get_tree", "-r")
+	cmd.Env = append(cmd.Env, "SWAYSOCK="+sockets[0])
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var root swayNode
+	if err := json.Unmarshal(out, &root); err != nil {
+		return nil, err
+	}
+
+	windows := []models.Window{}
+	var walk func(node swayNode, workspace string)
+	walk = func(node swayNode, workspace string) {
+		if node.Type == "workspace" {
+			workspace = node.Name
+		}
+		if (node.Type == "con" || node.Type == "floating_con") && node.PID > 0 {
+			appID := node.AppID
+			if appID == "" && node.Window != nil {
+				appID = node.Window.Class
+			}
+			windows = append(windows, models.Window{
+				ID:        fmt.Sprint(node.ID),
+				Title:     node.Name,
+				AppID:     appID,
+				PID:       node.PID,
+				Workspace: workspace,
+				Focused:   node.Focused,
+			})
+		}
+		for _, child := range node.Nodes {
+			walk(child, workspace)
+		}
+		for _, child := range node.Floats {
+			walk(child, workspace)
+		}
+	}
+	walk(root, "")
+
+	return windows, nil
+}
+
+func x11Windows() ([]models.Window, error) {
+	out, err := graphicalCommand(false, "wmctrl", "-lpx").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	windows := []models.Window{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// 0x03a00003  0 4242   firefox.Firefox  host Title with spaces
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		var pid int
+		fmt.Sscanf(fields[2], "%d", &pid)
+		title := ""
+		if len(fields) > 5 {
+			title = strings.Join(fields[5:], " ")
+		}
+		windows = append(windows, models.Window{
+			ID:        fields[0],
+			Title:     title,
+			AppID:     fields[3],
+			PID:       pid,
+			Workspace: fields[1],
+		})
+	}
+	return windows, nil
+}
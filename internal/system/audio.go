@@ -0,0 +1,288 @@
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nex-server/internal/models"
+	"strconv"
+	"strings"
+)
+
+func getVolume() (int, bool) {
+	out, err := userCommand("wpctl", "get-volume", "@DEFAULT_AUDIO_SINK@").Output()
+	if err == nil {
+		str := strings.TrimSpace(string(out))
+		if strings.HasPrefix(str, "Volume:") {
+			str = strings.TrimPrefix(str, "Volume:")
+			str = strings.TrimSpace(str)
+			parts := strings.Fields(str)
+			if len(parts) > 0 {
+				val, err := strconv.ParseFloat(parts[0], 64)
+				if err == nil {
+					return Round(val * 100), strings.Contains(str, "[MUTED]")
+				}
+			}
+		}
+	}
+
+	out, err = userCommand("pactl", "get-sink-volume", "@DEFAULT_SINK@").Output()
+	if err == nil {
+		str := string(out)
+		parts := strings.Split(str, "/")
+		if len(parts) >= 2 {
+			volStr := strings.TrimSpace(parts[1])
+			volStr = strings.TrimRight(volStr, "%")
+			vol, err := strconv.Atoi(volStr)
+			if err == nil {
+				muted := false
+				if out, err := userCommand("pactl", "get-sink-mute", "@DEFAULT_SINK@").Output(); err == nil {
+					muted = strings.Contains(string(out), "yes")
+				}
+				return vol, muted
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func SetVolume(percent int) error {
+	if percent < 0 || percent > 150 {
+		return errors.New("volume out of range")
+	}
+	if err := userCommand("pactl", "set-sink-volume", "@DEFAULT_SINK@", fmt.Sprintf("%d%%", percent)).Run(); err == nil {
+		return nil
+	}
+	return userCommand("wpctl", "set-volume", "@DEFAULT_AUDIO_SINK@", fmt.Sprintf("%.2f", float64(percent)/100)).Run()
+}
+
+func StepVolume(delta int) error {
+	current, _ := getVolume()
+	target := current + delta
+	if target < 0 {
+		target = 0
+	}
+	if target > 100 && current <= 100 {
+		target = 100
+	}
+	return SetVolume(target)
+}
+
+// SetMute accepts "toggle", "on"/"1" or "off"/"0", the same values pactl takes.
+func SetMute(mode string) error {
+	return setMute("@DEFAULT_SINK@", "@DEFAULT_AUDIO_SINK@", "set-sink-mute", mode)
+}
+
+func SetMicMute(mode string) error {
+	return setMute("@DEFAULT_SOURCE@", "@DEFAULT_AUDIO_SOURCE@", "set-source-mute", mode)
+}
+
+func muteMode(mode string) (string, error) {
+	switch mode {
+	case "", "toggle":
+		return "toggle", nil
+	case "on", "1", "true":
+		return "1", nil
+	case "off", "0", "false":
+		return "0", nil
+	}
+	return "", errors.New("invalid mute mode")
+}
+
+func setMute(pulseTarget, wpTarget, pactlCmd, mode string) error {
+	mode, err := muteMode(mode)
+	if err != nil {
+		return err
+	}
+	if err := userCommand("pactl", pactlCmd, pulseTarget, mode).Run(); err == nil {
+		return nil
+	}
+	return userCommand("wpctl", "set-mute", wpTarget, mode).Run()
+}
+
+func SetDefaultSink(name string) error {
+	if name == "" {
+		return errors.New("missing sink name")
+	}
+	return userCommand("pactl", "set-default-sink", name).Run()
+}
+
+func SetDefaultSource(name string) error {
+	if name == "" {
+		return errors.New("missing source name")
+	}
+	return userCommand("pactl", "set-default-source", name).Run()
+}
+
+func GetAudioDevices() (models.AudioDevices, error) {
+	devices := models.AudioDevices{
+		Sinks:   []models.AudioDevice{},
+		Sources: []models.AudioDevice{},
+	}
+
+	defaultSink := userOutput("pactl", "get-default-sink")
+	defaultSource := userOutput("pactl", "get-default-source")
+
+	sinks, err := listPulseDevices("sinks", defaultSink)
+	if err != nil {
+		return devices, err
+	}
+	devices.Sinks = sinks
+
+	sources, err := listPulseDevices("sources", defaultSource)
+	if err == nil {
+		for _, source := range sources {
+			if strings.HasSuffix(source.Name, ".monitor") {
+				continue
+			}
+			devices.Sources = append(devices.Sources, source)
+		}
+	}
+
+	return devices, nil
+}
+
+type pulseChannel struct {
+	ValuePercent string `json:"value_percent"`
+}
+
+type pulseDevice struct {
+	Index       int                     `json:"index"`
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Mute        bool                    `json:"mute"`
+	Volume      map[string]pulseChannel `json:"volume"`
+}
+
+func listPulseDevices(kind, defaultName string) ([]models.AudioDevice, error) {
+	out, err := userCommand("pactl", "-f", "json", "list", kind).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []pulseDevice
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, err
+	}
+
+	devices := make([]models.AudioDevice, 0, len(raw))
+	for _, dev := range raw {
+		devices = append(devices, models.AudioDevice{
+			Index:       dev.Index,
+			Name:        dev.Name,
+			Description: dev.Description,
+			Volume:      pulseVolume(dev.Volume),
+			Muted:       dev.Mute,
+			Default:     dev.Name == defaultName,
+		})
+	}
+	return devices, nil
+}
+
+// pulseVolume reports the loudest channel, which is what desktop volume
+// sliders show when the channels are unbalanced.
+func pulseVolume(channels map[string]pulseChannel) int {
+	max := 0
+	for _, ch := range channels {
+		if pct, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(ch.ValuePercent), "%")); err == nil && pct > max {
+			max = pct
+		}
+	}
+	return max
+}
+
+type pulseSinkInput struct {
+	Index      int                     `json:"index"`
+	Sink       int                     `json:"sink"`
+	Mute       bool                    `json:"mute"`
+	Volume     map[string]pulseChannel `json:"volume"`
+	Properties map[string]string       `json:"properties"`
+}
+
+// GetAudioStreams lists the applications currently playing through a sink.
+// Streams are tied to the MPRIS players by name, since neither pactl nor
+// playerctl expose a common identifier.
+func GetAudioStreams(players []models.AudioState) []models.AudioStream {
+	out, err := userCommand("pactl", "-f", "json", "list", "sink-inputs").Output()
+	if err != nil {
+		return []models.AudioStream{}
+	}
+
+	var inputs []pulseSinkInput
+	if err := json.Unmarshal(out, &inputs); err != nil {
+		return []models.AudioStream{}
+	}
+
+	sinkNames := map[int]string{}
+	if sinks, err := listPulseDevices("sinks", ""); err == nil {
+		for _, sink := range sinks {
+			sinkNames[sink.Index] = sink.Name
+		}
+	}
+
+	streams := make([]models.AudioStream, 0, len(inputs))
+	for _, input := range inputs {
+		app := input.Properties["application.name"]
+		binary := input.Properties["application.process.binary"]
+		if app == "" {
+			app = binary
+		}
+
+		streams = append(streams, models.AudioStream{
+			Index:       input.Index,
+			Application: app,
+			IconName:    input.Properties["application.icon_name"],
+			MediaTitle:  input.Properties["media.name"],
+			Volume:      pulseVolume(input.Volume),
+			Muted:       input.Mute,
+			Sink:        sinkNames[input.Sink],
+			PlayerID:    matchPlayer(players, app, binary),
+		})
+	}
+	return streams
+}
+
+func matchPlayer(players []models.AudioState, app, binary string) string {
+	candidates := []string{normalizeAppName(app), normalizeAppName(binary)}
+	for _, player := range players {
+		name := normalizeAppName(player.Name)
+		if name == "" {
+			continue
+		}
+		for _, candidate := range candidates {
+			if candidate != "" && (strings.Contains(candidate, name) || strings.Contains(name, candidate)) {
+				return player.ID
+			}
+		}
+	}
+	return ""
+}
+
+func normalizeAppName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "chromium", "chrome")
+	return strings.TrimSpace(name)
+}
+
+func SetStreamVolume(index, percent int) error {
+	if percent < 0 || percent > 150 {
+		return errors.New("volume out of range")
+	}
+	return userCommand("pactl", "set-sink-input-volume", strconv.Itoa(index), fmt.Sprintf("%d%%", percent)).Run()
+}
+
+func SetStreamMute(index int, mode string) error {
+	mode, err := muteMode(mode)
+	if err != nil {
+		return err
+	}
+	return userCommand("pactl", "set-sink-input-mute", strconv.Itoa(index), mode).Run()
+}
+
+func MoveStream(index int, sink string) error {
+	if sink == "" {
+		return errors.New("missing sink name")
+	}
+	return userCommand("pactl", "move-sink-input", strconv.Itoa(index), sink).Run()
+}
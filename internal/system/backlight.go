@@ -0,0 +1,320 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Dest    = "org.freedesktop.login1"
+	login1Path    = "/org/freedesktop/login1"
+	login1Manager = "org.freedesktop.login1.Manager"
+
+	i2cSlave        = 0x0703
+	ddcAddress      = 0x37
+	ddcHostAddress  = 0x51
+	ddcReplyAddress = 0x6E
+	vcpBrightness   = 0x10
+
+	ddcRefreshInterval = 30 * time.Second
+	ddcProbeInterval   = 5 * time.Minute
+)
+
+type ddcMonitor struct {
+	bus     string
+	name    string
+	current int
+	max     int
+}
+
+var ddcCache struct {
+	start    sync.Once
+	mu       sync.RWMutex
+	monitors []ddcMonitor
+}
+
+func GetBacklights() []models.BacklightDevice {
+	devices := []models.BacklightDevice{}
+
+	devices = append(devices, sysfsBacklights("backlight", "/sys/class/backlight/*", "screen")...)
+	devices = append(devices, sysfsBacklights("leds", "/sys/class/leds/*kbd_backlight*", "keyboard")...)
+
+	if config.Current.Backlight.DDC {
+		for _, mon := range ddcMonitors() {
+			devices = append(devices, models.BacklightDevice{
+				ID:            "ddc:" + mon.bus,
+				Name:          mon.name,
+				Type:          "external",
+				Brightness:    mon.current,
+				MaxBrightness: mon.max,
+				Percentage:    brightnessPercent(mon.current, mon.max),
+			})
+		}
+	}
+
+	return devices
+}
+
+func sysfsBacklights(subsystem, pattern, kind string) []models.BacklightDevice {
+	matches, _ := filepath.Glob(pattern)
+	sort.Strings(matches)
+
+	devices := []models.BacklightDevice{}
+	for _, dir := range matches {
+		max, okMax := readSysInt(filepath.Join(dir, "max_brightness"))
+		actual, okActual := readSysInt(filepath.Join(dir, "brightness"))
+		if !okMax || !okActual {
+			continue
+		}
+		name := filepath.Base(dir)
+		devices = append(devices, models.BacklightDevice{
+			ID:            subsystem + ":" + name,
+			Name:          name,
+			Type:          kind,
+			Brightness:    int(actual),
+			MaxBrightness: int(max),
+			Percentage:    brightnessPercent(int(actual), int(max)),
+		})
+	}
+	return devices
+}
+
+func brightnessPercent(actual, max int) int {
+	if max == 0 {
+		return 100
+	}
+	return int((float64(actual) / float64(max)) * 100)
+}
+
+func getBacklight(devices []models.BacklightDevice) int {
+	for _, device := range devices {
+		if device.Type == "screen" {
+			return device.Percentage
+		}
+	}
+	return 100
+}
+
+// SetBrightness takes an id as reported by GetBacklights ("backlight:<name>",
+// "leds:<name>" or "ddc:<bus>") and a percentage.
+func SetBrightness(id string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("brightness out of range")
+	}
+
+	subsystem, name, ok := strings.Cut(id, ":")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return errors.New("invalid backlight id")
+	}
+
+	if subsystem == "ddc" {
+		return setDDCBrightness(name, percent)
+	}
+	if subsystem != "backlight" && subsystem != "leds" {
+		return errors.New("invalid backlight id")
+	}
+
+	dir := filepath.Join("/sys/class", subsystem, name)
+	max, ok := readSysInt(filepath.Join(dir, "max_brightness"))
+	if !ok {
+		return errors.New("unknown backlight device")
+	}
+	value := uint32(float64(max) * float64(percent) / 100)
+
+	if err := setBrightnessLogind(subsystem, name, value); err == nil {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(dir, "brightness"), []byte(strconv.Itoa(int(value))), 0644)
+}
+
+// setBrightnessLogind goes through the desktop user's session, which is what
+// logind checks SetBrightness permissions against.
+func setBrightnessLogind(subsystem, name string, value uint32) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return err
+	}
+
+	session, err := userSession(conn)
+	if err != nil {
+		return err
+	}
+
+	return conn.Object(login1Dest, session).Call("org.freedesktop.login1.Session.SetBrightness", 0, subsystem, name, value).Err
+}
+
+func userSession(conn *dbus.Conn) (dbus.ObjectPath, error) {
+	var sessions []struct {
+		ID   string
+		UID  uint32
+		User string
+		Seat string
+		Path dbus.ObjectPath
+	}
+	if err := conn.Object(login1Dest, login1Path).Call(login1Manager+".ListSessions", 0).Store(&sessions); err != nil {
+		return "", err
+	}
+
+	uid := uint32(getRealUID())
+	for _, s := range sessions {
+		if s.UID == uid && s.Seat != "" {
+			return s.Path, nil
+		}
+	}
+	for _, s := range sessions {
+		if s.UID == uid {
+			return s.Path, nil
+		}
+	}
+	return "", errors.New("no session for the desktop user")
+}
+
+// ddcMonitors returns the monitors found by the background DDC/CI probe,
+// which the first call starts. A probe takes 50ms per bus, so stats never
+// wait on it; the list is empty until the first probe is done.
+func ddcMonitors() []ddcMonitor {
+	ddcCache.start.Do(func() { go probeDDC() })
+
+	ddcCache.mu.RLock()
+	defer ddcCache.mu.RUnlock()
+	return ddcCache.monitors
+}
+
+// probeDDC refreshes the known monitors every ddcRefreshInterval and looks
+// for new ones on every i2c bus every ddcProbeInterval.
+func probeDDC() {
+	var probed time.Time
+	for {
+		var buses []string
+		if time.Since(probed) >= ddcProbeInterval {
+			matches, _ := filepath.Glob("/dev/i2c-*")
+			for _, match := range matches {
+				buses = append(buses, filepath.Base(match))
+			}
+			sort.Strings(buses)
+			probed = time.Now()
+		} else {
+			for _, mon := range ddcMonitors() {
+				buses = append(buses, mon.bus)
+			}
+		}
+
+		monitors := []ddcMonitor{}
+		for _, bus := range buses {
+			current, max, err := getDDCBrightness(bus)
+			if err != nil {
+				continue
+			}
+			name := readSysString(filepath.Join("/sys/class/i2c-dev", bus, "name"))
+			if name == "" {
+				name = bus
+			}
+			monitors = append(monitors, ddcMonitor{bus: bus, name: name, current: current, max: max})
+		}
+
+		ddcCache.mu.Lock()
+		ddcCache.monitors = monitors
+		ddcCache.mu.Unlock()
+
+		time.Sleep(ddcRefreshInterval)
+	}
+}
+
+func openDDC(bus string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join("/dev", bus), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, ddcAddress); errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
+
+func ddcChecksum(initial byte, data []byte) byte {
+	sum := initial
+	for _, b := range data {
+		sum ^= b
+	}
+	return sum
+}
+
+func getDDCBrightness(bus string) (int, int, error) {
+	f, err := openDDC(bus)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	req := []byte{ddcHostAddress, 0x82, 0x01, vcpBrightness}
+	req = append(req, ddcChecksum(ddcReplyAddress, req))
+	if _, err := f.Write(req); err != nil {
+		return 0, 0, err
+	}
+
+	// DDC/CI requires the host to wait at least 40ms before reading a reply.
+	time.Sleep(50 * time.Millisecond)
+
+	reply := make([]byte, 11)
+	if _, err := f.Read(reply); err != nil {
+		return 0, 0, err
+	}
+	if reply[2] != 0x02 || reply[3] != 0x00 || reply[4] != vcpBrightness {
+		return 0, 0, fmt.Errorf("unexpected DDC reply on %s", bus)
+	}
+
+	max := int(reply[6])<<8 | int(reply[7])
+	current := int(reply[8])<<8 | int(reply[9])
+	return current, max, nil
+}
+
+func setDDCBrightness(bus string, percent int) error {
+	var max int
+	for _, mon := range ddcMonitors() {
+		if mon.bus == bus {
+			max = mon.max
+		}
+	}
+	if max == 0 {
+		return errors.New("unknown DDC monitor")
+	}
+
+	f, err := openDDC(bus)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	value := max * percent / 100
+	req := []byte{ddcHostAddress, 0x84, 0x03, vcpBrightness, byte(value >> 8), byte(value)}
+	req = append(req, ddcChecksum(ddcReplyAddress, req))
+	if _, err := f.Write(req); err != nil {
+		return err
+	}
+
+	// Callers hold on to the cached slice, so it is replaced, not modified.
+	ddcCache.mu.Lock()
+	monitors := append([]ddcMonitor{}, ddcCache.monitors...)
+	for i := range monitors {
+		if monitors[i].bus == bus {
+			monitors[i].current = value
+		}
+	}
+	ddcCache.monitors = monitors
+	ddcCache.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package system
+
+import (
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	upowerDest          = "org.freedesktop.UPower"
+	upowerPath          = "/org/freedesktop/UPower"
+	upowerDeviceIface   = "org.freedesktop.UPower.Device"
+	upowerDisplayDevice = "/org/freedesktop/UPower/devices/DisplayDevice"
+	powerSupplyRoot     = "/sys/class/power_supply"
+)
+
+var upowerStates = []string{"unknown", "charging", "discharging", "empty", "fully-charged", "pending-charge", "pending-discharge"}
+
+var upowerTypes = []string{
+	"unknown", "line-power", "battery", "ups", "monitor", "mouse", "keyboard", "pda", "phone",
+	"media-player", "tablet", "computer", "gaming-input", "pen", "touchpad", "modem", "network",
+	"headset", "speakers", "headphones", "video", "other-audio", "remote-control", "printer",
+	"scanner", "camera", "wearable", "toy", "bluetooth-generic",
+}
+
+type BatteryMonitor struct {
+	conn   *dbus.Conn
+	mu     sync.RWMutex
+	state  models.BatteryState
+	upower bool
+}
+
+func NewBatteryMonitor() *BatteryMonitor {
+	b := &BatteryMonitor{}
+
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return b
+	}
+	b.conn = conn
+
+	conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchPathNamespace(upowerPath),
+	)
+	conn.AddMatchSignal(dbus.WithMatchInterface(upowerDest))
+
+	signals := make(chan *dbus.Signal, 32)
+	conn.Signal(signals)
+
+	b.refresh()
+	go b.watch(signals)
+
+	return b
+}
+
+// State returns the state cached from the last UPower signal. Without
+// UPower there is nothing to subscribe to, so sysfs is read on every call.
+func (b *BatteryMonitor) State() models.BatteryState {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.upower {
+		return getBatteryStateFromSysfs()
+	}
+	return b.state
+}
+
+func (b *BatteryMonitor) watch(signals chan *dbus.Signal) {
+	for range signals {
+		// A single charger plug event fires a burst of PropertiesChanged
+		// signals; collapse whatever is already queued into one refresh.
+		for len(signals) > 0 {
+			<-signals
+		}
+		b.refresh()
+	}
+}
+
+func (b *BatteryMonitor) refresh() {
+	state, ok := b.readUPower()
+	if !ok {
+		state = getBatteryStateFromSysfs()
+	}
+
+	b.mu.Lock()
+	b.state = state
+	b.upower = ok
+	b.mu.Unlock()
+}
+
+func (b *BatteryMonitor) readUPower() (models.BatteryState, bool) {
+	root := b.conn.Object(upowerDest, upowerPath)
+
+	var paths []dbus.ObjectPath
+	if err := root.Call(upowerDest+".EnumerateDevices", 0).Store(&paths); err != nil {
+		return models.BatteryState{}, false
+	}
+
+	onBattery := false
+	if v, err := root.GetProperty(upowerDest + ".OnBattery"); err == nil {
+		onBattery, _ = v.Value().(bool)
+	}
+
+	state := models.BatteryState{
+		PluggedIn:   !onBattery,
+		State:       "unknown",
+		Batteries:   []models.BatteryDevice{},
+		Peripherals: []models.BatteryDevice{},
+	}
+
+	for _, path := range paths {
+		props, err := b.deviceProperties(path)
+		if err != nil {
+			continue
+		}
+		device := batteryDeviceFromProps(path, props)
+		if device.Type == "line-power" {
+			continue
+		}
+
+		powerSupply, _ := props["PowerSupply"].Value().(bool)
+		if device.Type == "battery" && powerSupply {
+			state.Batteries = append(state.Batteries, device)
+		} else {
+			state.Peripherals = append(state.Peripherals, device)
+		}
+	}
+
+	if props, err := b.deviceProperties(upowerDisplayDevice); err == nil {
+		display := batteryDeviceFromProps(upowerDisplayDevice, props)
+		state.Present = display.Present && len(state.Batteries) > 0
+		if state.Present {
+			state.State = display.State
+			state.Percentage = Round(display.Percentage)
+			state.EnergyRate = display.EnergyRate
+			state.TimeToEmpty = display.TimeToEmpty
+			state.TimeToFull = display.TimeToFull
+		}
+	}
+
+	return state, true
+}
+
+func (b *BatteryMonitor) deviceProperties(path dbus.ObjectPath) (map[string]dbus.Variant, error) {
+	var props map[string]dbus.Variant
+	err := b.conn.Object(upowerDest, path).Call("org.freedesktop.DBus.Properties.GetAll", 0, upowerDeviceIface).Store(&props)
+	return props, err
+}
+
+func batteryDeviceFromProps(path dbus.ObjectPath, props map[string]dbus.Variant) models.BatteryDevice {
+	device := models.BatteryDevice{
+		ID:         filepath.Base(string(path)),
+		Type:       enumName(upowerTypes, props["Type"]),
+		State:      enumName(upowerStates, props["State"]),
+		CycleCount: -1,
+	}
+
+	device.Vendor, _ = props["Vendor"].Value().(string)
+	device.Model, _ = props["Model"].Value().(string)
+	device.Present, _ = props["IsPresent"].Value().(bool)
+	device.Percentage, _ = props["Percentage"].Value().(float64)
+	device.EnergyRate, _ = props["EnergyRate"].Value().(float64)
+	device.TimeToEmpty, _ = props["TimeToEmpty"].Value().(int64)
+	device.TimeToFull, _ = props["TimeToFull"].Value().(int64)
+	device.Capacity, _ = props["Capacity"].Value().(float64)
+	if cycles, ok := props["ChargeCycles"].Value().(int32); ok {
+		device.CycleCount = cycles
+	}
+
+	return device
+}
+
+func enumName(names []string, v dbus.Variant) string {
+	idx, ok := v.Value().(uint32)
+	if !ok || int(idx) >= len(names) {
+		return names[0]
+	}
+	return names[idx]
+}
+
+func getBatteryStateFromSysfs() models.BatteryState {
+	state := models.BatteryState{
+		State:       "unknown",
+		Batteries:   []models.BatteryDevice{},
+		Peripherals: []models.BatteryDevice{},
+	}
+
+	entries, err := os.ReadDir(powerSupplyRoot)
+	if err != nil {
+		state.PluggedIn = true
+		return state
+	}
+
+	hasMains := false
+	for _, entry := range entries {
+		dir := filepath.Join(powerSupplyRoot, entry.Name())
+		switch readSysString(filepath.Join(dir, "type")) {
+		case "Mains":
+			hasMains = true
+			if online, _ := readSysInt(filepath.Join(dir, "online")); online == 1 {
+				state.PluggedIn = true
+			}
+		case "Battery":
+			if readSysString(filepath.Join(dir, "scope")) == "Device" {
+				continue
+			}
+			state.Batteries = append(state.Batteries, sysfsBattery(entry.Name(), dir))
+		}
+	}
+
+	if !hasMains {
+		state.PluggedIn = len(state.Batteries) == 0
+	}
+
+	if len(state.Batteries) > 0 {
+		main := state.Batteries[0]
+		state.Present = main.Present
+		state.State = main.State
+		state.Percentage = Round(main.Percentage)
+		state.EnergyRate = main.EnergyRate
+	}
+
+	return state
+}
+
+func sysfsBattery(name, dir string) models.BatteryDevice {
+	device := models.BatteryDevice{
+		ID:         name,
+		Type:       "battery",
+		CycleCount: -1,
+	}
+
+	device.Vendor = readSysString(filepath.Join(dir, "manufacturer"))
+	device.Model = readSysString(filepath.Join(dir, "model_name"))
+	present, ok := readSysInt(filepath.Join(dir, "present"))
+	device.Present = !ok || present == 1
+
+	if capacity, ok := readSysInt(filepath.Join(dir, "capacity")); ok {
+		device.Percentage = float64(capacity)
+	}
+	if power, ok := readSysInt(filepath.Join(dir, "power_now")); ok {
+		device.EnergyRate = float64(power) / 1e6
+	}
+	if cycles, ok := readSysInt(filepath.Join(dir, "cycle_count")); ok {
+		device.CycleCount = int32(cycles)
+	}
+	full, okFull := readSysInt(filepath.Join(dir, "energy_full"))
+	design, okDesign := readSysInt(filepath.Join(dir, "energy_full_design"))
+	if okFull && okDesign && design > 0 {
+		device.Capacity = ToFixed(float64(full)/float64(design)*100, 1)
+	}
+
+	switch strings.ToLower(readSysString(filepath.Join(dir, "status"))) {
+	case "charging":
+		device.State = "charging"
+	case "discharging":
+		device.State = "discharging"
+	case "full":
+		device.State = "fully-charged"
+	case "not charging":
+		device.State = "pending-charge"
+	default:
+		device.State = "unknown"
+	}
+
+	return device
+}
@@ -0,0 +1,187 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const clipboardPollInterval = 2 * time.Second
+
+var clipboardImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type ClipboardMonitor struct {
+	emit func(event string, payload interface{})
+
+	mu       sync.Mutex
+	lastHash [32]byte
+}
+
+func NewClipboardMonitor(emit func(event string, payload interface{})) *ClipboardMonitor {
+	c := &ClipboardMonitor{emit: emit}
+	if config.Current.Clipboard.Disable {
+		return c
+	}
+
+	go c.watch()
+	return c
+}
+
+func (c *ClipboardMonitor) watch() {
+	if waylandDisplay() != "" {
+		// wl-paste --watch only works on compositors implementing the
+		// data-control protocol; it exits right away everywhere else.
+		cmd := graphicalCommand(true, "wl-paste", "--watch", "echo")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil && cmd.Start() == nil {
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				c.check()
+			}
+			cmd.Wait()
+		}
+	}
+
+	for {
+		c.check()
+		time.Sleep(clipboardPollInterval)
+	}
+}
+
+func (c *ClipboardMonitor) check() {
+	content, hash, err := readClipboard()
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	changed := hash != c.lastHash
+	c.lastHash = hash
+	c.mu.Unlock()
+
+	if changed {
+		c.emit("clipboard-changed", content)
+	}
+}
+
+// clipboardHash identifies clipboard content by its raw bytes, so changes
+// are seen even when the content is too large to be sent.
+func clipboardHash(mime string, raw []byte) [32]byte {
+	return sha256.Sum256(append([]byte(mime+"\x00"), raw...))
+}
+
+// readClipboard returns the clipboard content along with its clipboardHash.
+func readClipboard() (models.ClipboardContent, [32]byte, error) {
+	wayland := waylandDisplay() != ""
+
+	var types []string
+	var out []byte
+	var err error
+	if wayland {
+		out, err = graphicalCommand(true, "wl-paste", "--list-types").Output()
+	} else {
+		out, err = graphicalCommand(false, "xclip", "-selection", "clipboard", "-t", "TARGETS", "-o").Output()
+	}
+	if err != nil {
+		return models.ClipboardContent{}, [32]byte{}, err
+	}
+	types = strings.Fields(string(out))
+
+	mime := "text/plain"
+	for _, imageType := range clipboardImageTypes {
+		if containsString(types, imageType) {
+			mime = imageType
+			break
+		}
+	}
+
+	if wayland {
+		args := []string{"-n"}
+		if mime != "text/plain" {
+			args = append(args, "-t", mime)
+		}
+		out, err = graphicalCommand(true, "wl-paste", args...).Output()
+	} else {
+		target := mime
+		if mime == "text/plain" {
+			target = "UTF8_STRING"
+		}
+		out, err = graphicalCommand(false, "xclip", "-selection", "clipboard", "-t", target, "-o").Output()
+	}
+	if err != nil {
+		return models.ClipboardContent{}, [32]byte{}, err
+	}
+
+	hash := clipboardHash(mime, out)
+	content := models.ClipboardContent{Mime: mime, Size: len(out)}
+	if len(out) > int(UploadLimit()) {
+		content.Truncated = true
+		return content, hash, nil
+	}
+	if mime == "text/plain" {
+		content.Type = "text"
+		content.Text = string(out)
+	} else {
+		content.Type = "image"
+		content.Data = base64.StdEncoding.EncodeToString(out)
+	}
+	return content, hash, nil
+}
+
+// Set replaces the desktop clipboard. data is plain text for text/plain and
+// base64 for images.
+func (c *ClipboardMonitor) Set(mime, data string) error {
+	if config.Current.Clipboard.Disable {
+		return errors.New("clipboard is disabled")
+	}
+
+	payload := []byte(data)
+	if mime != "text/plain" {
+		if !containsString(clipboardImageTypes, mime) {
+			return errors.New("unsupported clipboard type")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return err
+		}
+		payload = decoded
+	}
+	if len(payload) > int(UploadLimit()) {
+		return errors.New("clipboard content too large")
+	}
+
+	var cmd *exec.Cmd
+	if waylandDisplay() != "" {
+		cmd = graphicalCommand(true, "wl-copy", "--type", mime)
+	} else {
+		cmd = graphicalCommand(false, "xclip", "-selection", "clipboard", "-t", mime, "-i")
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	// Remember what we wrote so the watcher does not echo it back.
+	c.mu.Lock()
+	c.lastHash = clipboardHash(mime, payload)
+	c.mu.Unlock()
+
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package system
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	commandPath    = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	commandMaxLine = 1024 * 1024
+)
+
+// ListCommands returns the configured macros the allowed func accepts,
+// sorted by name.
+func ListCommands(allowed func(config.CommandConfig) bool) []models.CommandInfo {
+	commands := []models.CommandInfo{}
+	for name, macro := range config.Current.Commands {
+		if !allowed(macro) {
+			continue
+		}
+		commands = append(commands, models.CommandInfo{
+			Name:        name,
+			Description: macro.Description,
+			AllowArgs:   macro.AllowArgs,
+		})
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// RunCommand executes the macro called name and blocks until it exits,
+// passing every stdout/stderr line to output as it is printed. extra is
+// appended to the configured argv when the macro sets allow_args. The
+// process runs in its own process group so the whole group is killed when
+// the timeout expires or cancel is closed.
+func RunCommand(id, name string, extra []string, cancel <-chan struct{}, output func(stream, line string)) models.CommandExit {
+	result := models.CommandExit{ID: id, Name: name, Code: -1}
+
+	macro, ok := config.Current.Commands[name]
+	if !ok || len(macro.Argv) == 0 {
+		result.Error = "unknown command"
+		return result
+	}
+	if len(extra) > 0 && !macro.AllowArgs {
+		result.Error = "command does not accept arguments"
+		return result
+	}
+
+	timeout := macro.Timeout
+	if timeout <= 0 {
+		timeout = config.Current.Timeout
+	}
+	if timeout <= 0 {
+		timeout = 60
+	}
+
+	env, home, cred, err := commandIdentity(macro.User)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for key, value := range macro.Env {
+		env = append(env, key+"="+value)
+	}
+
+	argv := append(append([]string{}, macro.Argv...), extra...)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	cmd.Dir = macro.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = home
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		timedOut.Store(true)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go streamLines(stdout, "stdout", output, &wg)
+	go streamLines(stderr, "stderr", output, &wg)
+	wg.Wait()
+
+	err = cmd.Wait()
+	timer.Stop()
+	close(exited)
+
+	result.Duration = time.Since(start).Milliseconds()
+	result.TimedOut = timedOut.Load()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.Code = 0
+	case errors.As(err, &exitErr):
+		result.Code = exitErr.ExitCode()
+	default:
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func streamLines(r io.Reader, stream string, output func(stream, line string), wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), commandMaxLine)
+	for scanner.Scan() {
+		output(stream, scanner.Text())
+	}
+	// Keep draining after an overlong line so the process never blocks on
+	// a full pipe.
+	io.Copy(io.Discard, r)
+}
+
+// commandIdentity resolves the account a macro runs as, defaulting to the
+// desktop user, and builds a clean environment for it. The server's own
+// environment is not inherited.
+func commandIdentity(runAs string) ([]string, string, *syscall.Credential, error) {
+	if runAs == "" {
+		runAs = getUsernameFromUID(getRealUID())
+	}
+
+	account, err := user.Lookup(runAs)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("unknown user %s", runAs)
+	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+
+	env := []string{
+		commandPath,
+		"HOME=" + account.HomeDir,
+		"USER=" + account.Username,
+		"LOGNAME=" + account.Username,
+	}
+	runtimeDir := fmt.Sprintf("/run/user/%d", uid)
+	if _, err := os.Stat(runtimeDir); err == nil {
+		env = append(env,
+			"XDG_RUNTIME_DIR="+runtimeDir,
+			fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=unix:path=%s/bus", runtimeDir),
+		)
+	}
+
+	if uid == os.Getuid() {
+		return env, account.HomeDir, nil, nil
+	}
+	if os.Getuid() != 0 {
+		return nil, "", nil, fmt.Errorf("cannot run as %s without root", runAs)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if groups, err := account.GroupIds(); err == nil {
+		for _, group := range groups {
+			if id, err := strconv.Atoi(group); err == nil {
+				cred.Groups = append(cred.Groups, uint32(id))
+			}
+		}
+	}
+	return env, account.HomeDir, cred, nil
+}
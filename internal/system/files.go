@@ -0,0 +1,342 @@
+package system
+
+import (
+	"errors"
+	"io"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const uploadSuffix = ".nexpart"
+
+var (
+	ErrUnknownRoot   = errors.New("unknown root")
+	ErrOutsideRoot   = errors.New("path outside of root")
+	ErrRootPath      = errors.New("operation not allowed on the root itself")
+	ErrOffsetInvalid = errors.New("offset does not match the uploaded size")
+	ErrNotRegular    = errors.New("not a regular file")
+)
+
+// UploadLimit is api.upload_limit in bytes. The setting is in KiB.
+func UploadLimit() int64 {
+	if config.Current.API.UploadLimit <= 0 {
+		return 4064 * 1024
+	}
+	return config.Current.API.UploadLimit * 1024
+}
+
+func FileRoots() []string {
+	roots := []string{}
+	for name := range config.Current.Files.Roots {
+		roots = append(roots, name)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// ResolvePath maps a client path inside the named root to an absolute path.
+// Every symlink along the way is resolved before the containment check, so
+// neither ".." nor a link pointing outside lets a client leave the root.
+// The last components may not exist yet (mkdir, upload).
+func ResolvePath(root, rel string) (string, error) {
+	base, ok := config.Current.Files.Roots[root]
+	if !ok || base == "" {
+		return "", ErrUnknownRoot
+	}
+	if strings.ContainsRune(rel, 0) {
+		return "", ErrOutsideRoot
+	}
+
+	realBase, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := resolveExisting(filepath.Join(realBase, filepath.Clean("/"+rel)))
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(realBase, resolved) {
+		return "", ErrOutsideRoot
+	}
+	return resolved, nil
+}
+
+// resolveExisting evaluates the symlinks of the longest existing prefix of
+// path and appends the missing components unchanged. A dangling link is
+// followed to where it points, as creating a file through it would.
+func resolveExisting(path string) (string, error) {
+	missing := ""
+	for links := 0; ; {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if target, linkErr := os.Readlink(path); linkErr == nil {
+			if links++; links > 255 {
+				return "", syscall.ELOOP
+			}
+			dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+			path = target
+			continue
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(path), missing)
+		path = parent
+	}
+}
+
+// resolveEntry is ResolvePath for operations on the entry itself: only the
+// parent directory is resolved, so a symlink named by rel is renamed or
+// removed instead of its target.
+func resolveEntry(root, rel string) (string, error) {
+	clean := filepath.Clean("/" + rel)
+	if clean == "/" {
+		return "", ErrRootPath
+	}
+	parent, err := ResolvePath(root, filepath.Dir(clean))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(clean)), nil
+}
+
+// OpenFile opens a path returned by ResolvePath for writing. The last
+// component is not followed, so a symlink swapped in after the check (or
+// planted by the desktop user) cannot send the write outside of the root,
+// and anything but a regular file is refused.
+func OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, ErrOutsideRoot
+		}
+		return nil, ErrNotRegular
+	}
+
+	f, err := os.OpenFile(path, flag|syscall.O_NOFOLLOW, perm)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, ErrOutsideRoot
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrNotRegular
+	}
+	return f, nil
+}
+
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func isRoot(root, path string) bool {
+	realBase, err := filepath.EvalSymlinks(config.Current.Files.Roots[root])
+	return err != nil || realBase == path
+}
+
+// StatPath describes rel, listing its entries when it is a directory.
+func StatPath(root, rel string) (models.FileEntry, error) {
+	path, err := ResolvePath(root, rel)
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+	entry := fileEntry(info, filepath.Clean("/"+rel))
+	if !info.IsDir() {
+		return entry, nil
+	}
+
+	children, err := os.ReadDir(path)
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+	entry.Entries = []models.FileEntry{}
+	for _, child := range children {
+		if strings.HasSuffix(child.Name(), uploadSuffix) {
+			continue
+		}
+		childInfo, err := child.Info()
+		if err != nil {
+			continue
+		}
+		entry.Entries = append(entry.Entries, fileEntry(childInfo, filepath.Join(entry.Path, child.Name())))
+	}
+	return entry, nil
+}
+
+func fileEntry(info os.FileInfo, rel string) models.FileEntry {
+	entry := models.FileEntry{
+		Name:     info.Name(),
+		Path:     rel,
+		Type:     "other",
+		Size:     info.Size(),
+		Mode:     info.Mode().Perm().String(),
+		Modified: info.ModTime().Unix(),
+	}
+	if rel == "/" {
+		entry.Name = "/"
+	}
+
+	switch {
+	case info.Mode().IsRegular():
+		entry.Type = "file"
+	case info.IsDir():
+		entry.Type = "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		entry.Type = "symlink"
+	}
+	return entry
+}
+
+func MakeDirectory(root, rel string) error {
+	path, err := ResolvePath(root, rel)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		return err
+	}
+	MatchOwner(path)
+	return nil
+}
+
+// RenamePath and DeletePath act on the entry named by the client, a
+// symlink is moved or removed, never what it points to.
+func RenamePath(root, from, to string) error {
+	src, err := resolveEntry(root, from)
+	if err != nil {
+		return err
+	}
+	dst, err := resolveEntry(root, to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return os.ErrExist
+	}
+	return os.Rename(src, dst)
+}
+
+func DeletePath(root, rel string, recursive bool) error {
+	path, err := resolveEntry(root, rel)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	if recursive {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+// WriteChunk appends one upload chunk to a hidden part file next to the
+// target. offset has to match what was received so far, which lets a client
+// resume an interrupted upload by asking for the current offset (a
+// mismatch returns the size already written). The part file replaces the
+// target once complete is set.
+func WriteChunk(root, rel string, offset int64, body io.Reader, complete bool) (models.UploadStatus, error) {
+	path, err := ResolvePath(root, rel)
+	if err != nil {
+		return models.UploadStatus{}, err
+	}
+	if isRoot(root, path) {
+		return models.UploadStatus{}, ErrRootPath
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return models.UploadStatus{}, os.ErrExist
+	}
+	part := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+uploadSuffix)
+
+	// A new upload always starts from a part file of its own, whatever was
+	// left at that name before.
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+			return models.UploadStatus{}, err
+		}
+		flags |= os.O_EXCL
+	}
+	f, err := OpenFile(part, flags, 0644)
+	if err != nil {
+		return models.UploadStatus{}, err
+	}
+	defer f.Close()
+	MatchOwner(part)
+
+	info, err := f.Stat()
+	if err != nil {
+		return models.UploadStatus{}, err
+	}
+	// A hard link could point a resumed upload at a file outside the root.
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+		return models.UploadStatus{}, ErrOutsideRoot
+	}
+	if info.Size() != offset {
+		return models.UploadStatus{Offset: info.Size()}, ErrOffsetInvalid
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return models.UploadStatus{}, err
+	}
+	written, err := io.Copy(f, body)
+	status := models.UploadStatus{Offset: offset + written}
+	if err != nil {
+		return status, err
+	}
+
+	if complete {
+		if err := os.Rename(part, path); err != nil {
+			return status, err
+		}
+		status.Complete = true
+	}
+	return status, nil
+}
+
+// MatchOwner hands a new file to the owner of its directory, the server
+// usually runs as root while the roots belong to the desktop user.
+func MatchOwner(path string) {
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Lchown(path, int(stat.Uid), int(stat.Gid))
+	}
+}
@@ -0,0 +1,221 @@
+package system
+
+import (
+	"errors"
+	"nex-server/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestRoot configures a single root "test" and returns it along with a
+// directory next to it that must stay out of reach.
+func newTestRoot(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{root, outside, filepath.Join(root, "docs")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config.Current = &config.Config{}
+	config.Current.Files.Roots = map[string]string{"test": root}
+	return root, outside
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", path, data, want)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	root, outside := newTestRoot(t)
+	symlink(t, outside, filepath.Join(root, "escape"))
+	symlink(t, "docs", filepath.Join(root, "inside"))
+	symlink(t, "../../outside/secret", filepath.Join(root, "docs", "relative"))
+
+	tests := []struct {
+		rel  string
+		want string
+		err  error
+	}{
+		{rel: "/", want: root},
+		{rel: "docs/a.txt", want: filepath.Join(root, "docs", "a.txt")},
+		{rel: "../../outside", want: filepath.Join(root, "outside")},
+		{rel: "docs/../../outside/secret", want: filepath.Join(root, "outside", "secret")},
+		{rel: "inside/new/file", want: filepath.Join(root, "docs", "new", "file")},
+		{rel: "escape", err: ErrOutsideRoot},
+		{rel: "escape/secret", err: ErrOutsideRoot},
+		{rel: "docs/relative", err: ErrOutsideRoot},
+		{rel: "docs/a\x00b", err: ErrOutsideRoot},
+	}
+	for _, test := range tests {
+		got, err := ResolvePath("test", test.rel)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ResolvePath(%q) = %q, %v; want %q, %v", test.rel, got, err, test.want, test.err)
+		}
+	}
+
+	if _, err := ResolvePath("missing", "/"); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("unknown root: got %v", err)
+	}
+}
+
+func TestWriteChunk(t *testing.T) {
+	root, _ := newTestRoot(t)
+
+	if _, err := WriteChunk("test", "docs/a.txt", 0, strings.NewReader("hello "), false); err != nil {
+		t.Fatal(err)
+	}
+	status, err := WriteChunk("test", "docs/a.txt", 3, strings.NewReader("x"), false)
+	if !errors.Is(err, ErrOffsetInvalid) || status.Offset != 6 {
+		t.Errorf("wrong offset: got %+v, %v", status, err)
+	}
+	status, err = WriteChunk("test", "docs/a.txt", 6, strings.NewReader("world"), true)
+	if err != nil || !status.Complete || status.Offset != 11 {
+		t.Fatalf("last chunk: got %+v, %v", status, err)
+	}
+	assertContent(t, filepath.Join(root, "docs", "a.txt"), "hello world")
+	if _, err := os.Lstat(filepath.Join(root, "docs", ".a.txt"+uploadSuffix)); !os.IsNotExist(err) {
+		t.Error("part file left behind")
+	}
+
+	if _, err := WriteChunk("test", "/", 0, strings.NewReader("x"), true); !errors.Is(err, ErrRootPath) {
+		t.Errorf("upload to the root: got %v", err)
+	}
+	if _, err := WriteChunk("test", "docs", 0, strings.NewReader("x"), true); !errors.Is(err, os.ErrExist) {
+		t.Errorf("upload over a directory: got %v", err)
+	}
+}
+
+func TestWriteChunkPlantedPart(t *testing.T) {
+	root, outside := newTestRoot(t)
+	secret := filepath.Join(outside, "shadow")
+	writeFile(t, secret, "secret")
+
+	// Links planted by whoever can write to the root, pointing the part
+	// file of an upload at a file outside of it.
+	symlink(t, secret, filepath.Join(root, ".link.txt"+uploadSuffix))
+	if err := os.Link(secret, filepath.Join(root, ".hard.txt"+uploadSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, secret, filepath.Join(root, "target.txt"))
+
+	for _, rel := range []string{"link.txt", "hard.txt"} {
+		if _, err := WriteChunk("test", rel, 6, strings.NewReader("pwned"), true); err == nil {
+			t.Errorf("resumed %s through a planted link", rel)
+		}
+	}
+	assertContent(t, secret, "secret")
+
+	// A new upload replaces the planted link with a part file of its own.
+	for _, rel := range []string{"link.txt", "hard.txt"} {
+		if _, err := WriteChunk("test", rel, 0, strings.NewReader("new"), true); err != nil {
+			t.Errorf("new upload of %s: %v", rel, err)
+		}
+		assertContent(t, filepath.Join(root, rel), "new")
+	}
+	assertContent(t, secret, "secret")
+
+	if _, err := WriteChunk("test", "target.txt", 0, strings.NewReader("pwned"), true); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("upload through a link out of the root: got %v", err)
+	}
+	assertContent(t, secret, "secret")
+}
+
+func TestOpenFile(t *testing.T) {
+	root, outside := newTestRoot(t)
+	secret := filepath.Join(outside, "shadow")
+	writeFile(t, secret, "secret")
+	symlink(t, secret, filepath.Join(root, "link"))
+
+	if _, err := OpenFile(filepath.Join(root, "link"), os.O_WRONLY|os.O_TRUNC, 0644); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("opened a symlink: %v", err)
+	}
+	if _, err := OpenFile(filepath.Join(root, "docs"), os.O_WRONLY, 0644); !errors.Is(err, ErrNotRegular) {
+		t.Errorf("opened a directory: %v", err)
+	}
+	assertContent(t, secret, "secret")
+
+	f, err := OpenFile(filepath.Join(root, "new"), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestDeletePathSymlinks(t *testing.T) {
+	root, outside := newTestRoot(t)
+	writeFile(t, filepath.Join(root, "docs", "keep.txt"), "keep")
+	writeFile(t, filepath.Join(outside, "keep.txt"), "keep")
+	symlink(t, "docs", filepath.Join(root, "inside"))
+	symlink(t, outside, filepath.Join(root, "escape"))
+
+	for _, rel := range []string{"inside", "escape"} {
+		if err := DeletePath("test", rel, true); err != nil {
+			t.Errorf("delete %s: %v", rel, err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, rel)); !os.IsNotExist(err) {
+			t.Errorf("link %s not removed", rel)
+		}
+	}
+	assertContent(t, filepath.Join(root, "docs", "keep.txt"), "keep")
+	assertContent(t, filepath.Join(outside, "keep.txt"), "keep")
+
+	if err := DeletePath("test", "/", true); !errors.Is(err, ErrRootPath) {
+		t.Errorf("delete the root: got %v", err)
+	}
+	if err := DeletePath("test", "escape/keep.txt", false); !os.IsNotExist(err) && !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("delete through a removed link: got %v", err)
+	}
+}
+
+func TestRenamePathSymlinks(t *testing.T) {
+	root, outside := newTestRoot(t)
+	symlink(t, outside, filepath.Join(root, "escape"))
+
+	if err := RenamePath("test", "escape", "docs/moved"); err != nil {
+		t.Fatalf("rename a link pointing out of the root: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "docs", "moved")); err != nil || target != outside {
+		t.Errorf("link not moved: %q, %v", target, err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("link target touched: %v", err)
+	}
+
+	if err := RenamePath("test", "docs/moved/x", "y"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("rename through a link out of the root: got %v", err)
+	}
+	if err := RenamePath("test", "docs", "../.."); !errors.Is(err, ErrRootPath) {
+		t.Errorf("rename onto the root: got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package system
+
+import (
+	"errors"
+	"nex-server/internal/models"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const keepAwakeWhat = "sleep:idle:handle-lid-switch"
+
+type keepAwake struct {
+	file  *os.File
+	until time.Time
+	timer *time.Timer
+}
+
+// KeepAwake takes a logind block inhibitor and holds its file descriptor
+// until disabled or until duration elapses (zero means no limit). logind
+// drops the lock by itself if the server dies with the fd open.
+func (p *PowerController) KeepAwake(enabled bool, duration time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.releaseKeepAwake()
+	if !enabled {
+		return nil
+	}
+	if p.conn == nil {
+		return errors.New("system bus unavailable")
+	}
+
+	var fd dbus.UnixFD
+	err := p.conn.Object(login1Dest, login1Path).Call(login1Manager+".Inhibit", 0,
+		keepAwakeWhat, "nex-server", "Keep awake requested from Nex Viewer", "block").Store(&fd)
+	if err != nil {
+		return err
+	}
+
+	lock := &keepAwake{file: os.NewFile(uintptr(fd), "nex-inhibit")}
+	if duration > 0 {
+		lock.until = time.Now().Add(duration)
+		lock.timer = time.AfterFunc(duration, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.keepAwake == lock {
+				p.releaseKeepAwake()
+			}
+		})
+	}
+	p.keepAwake = lock
+	return nil
+}
+
+func (p *PowerController) releaseKeepAwake() {
+	if p.keepAwake == nil {
+		return
+	}
+	if p.keepAwake.timer != nil {
+		p.keepAwake.timer.Stop()
+	}
+	p.keepAwake.file.Close()
+	p.keepAwake = nil
+}
+
+func (p *PowerController) keepAwakeState() models.KeepAwakeState {
+	state := models.KeepAwakeState{}
+	if p.keepAwake != nil {
+		state.Active = true
+		if !p.keepAwake.until.IsZero() {
+			state.Until = p.keepAwake.until.Unix()
+		}
+	}
+	return state
+}
+
+func (p *PowerController) inhibitors() []models.Inhibitor {
+	inhibitors := []models.Inhibitor{}
+	if p.conn == nil {
+		return inhibitors
+	}
+
+	var list []struct {
+		What string
+		Who  string
+		Why  string
+		Mode string
+		UID  uint32
+		PID  uint32
+	}
+	if err := p.conn.Object(login1Dest, login1Path).Call(login1Manager+".ListInhibitors", 0).Store(&list); err != nil {
+		return inhibitors
+	}
+
+	for _, entry := range list {
+		inhibitors = append(inhibitors, models.Inhibitor{
+			What: entry.What,
+			Who:  entry.Who,
+			Why:  entry.Why,
+			Mode: entry.Mode,
+			UID:  entry.UID,
+			PID:  entry.PID,
+		})
+	}
+	return inhibitors
+}
@@ -2,6 +2,7 @@ package system
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"nex-server/internal/models"
@@ -9,10 +10,39 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/godbus/dbus/v5"
 )
 
+const maxPublishedArt = 256
+
+// ErrUnknownPlayer is returned by Control for an ID missing from the stats.
+var ErrUnknownPlayer = errors.New("unknown player")
+
+var (
+	artMu    sync.Mutex
+	artPaths = map[string]bool{}
+)
+
+// publishArt records a local cover file linked from the stats; /v1/img/tmp
+// serves nothing else.
+func publishArt(path string) {
+	artMu.Lock()
+	defer artMu.Unlock()
+
+	if len(artPaths) >= maxPublishedArt {
+		artPaths = map[string]bool{}
+	}
+	artPaths[path] = true
+}
+
+func IsPublishedArt(path string) bool {
+	artMu.Lock()
+	defer artMu.Unlock()
+	return artPaths[path]
+}
+
 type MediaController struct {
 	conn *dbus.Conn
 	uid  int
@@ -21,9 +51,7 @@ type MediaController struct {
 func NewMediaController() *MediaController {
 	uid := getRealUserID()
 	
-	busAddress := fmt.Sprintf("unix:path=/run/user/%d/bus", uid)
-	
-	conn, err := dbus.Connect(busAddress)
+	conn, err := dbus.Connect(sessionBusAddress())
 	if err != nil {
 		return &MediaController{uid: uid}
 	}
@@ -110,6 +138,7 @@ func (m *MediaController) getStatusViaPlayerctl() []models.AudioState {
 
 			if strings.HasPrefix(state.ArtURL, "file://") {
 				path := strings.TrimPrefix(state.ArtURL, "file://")
+				publishArt(path)
 				encoded := base64.URLEncoding.EncodeToString([]byte(path))
 				state.ArtURL = fmt.Sprintf("/v1/img/tmp/%s", encoded)
 			}
@@ -465,20 +494,28 @@ func (m *MediaController) listNames() ([]string, error) {
 	return names, err
 }
 
-func (m *MediaController) Control(playerID string, command string) {
+// Control runs a playerctl command on the nth player with a title, args
+// are passed after the command (e.g. position 42).
+// Control runs a playerctl command on the player with the ID from the stats.
+func (m *MediaController) Control(playerID string, command string, args ...string) error {
 	if !strings.HasPrefix(playerID, "player") {
-		return
+		return ErrUnknownPlayer
 	}
 	idNum, err := strconv.Atoi(strings.TrimPrefix(playerID, "player"))
 	if err != nil {
-		return
+		return ErrUnknownPlayer
 	}
 
 	username := m.getUsername()
 	cmd := exec.Command("runuser", "-u", username, "--", "env", fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", m.uid), "playerctl", "-l")
 	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// playerctl exits with 1 when no player is running.
+		return ErrUnknownPlayer
+	}
 	if err != nil {
-		return
+		return err
 	}
 	
 	players := strings.Split(strings.TrimSpace(string(out)), "\n")
@@ -493,13 +530,21 @@ func (m *MediaController) Control(playerID string, command string) {
 		if state.Title != "" {
 			validCounter++
 			if validCounter == idNum {
-				m.execPlayerCommand(player, username, command)
-				return
+				return m.execPlayerCommand(player, username, command, args...)
 			}
 		}
 	}
+	return ErrUnknownPlayer
 }
 
-func (m *MediaController) execPlayerCommand(player, username, command string) {
-	exec.Command("runuser", "-u", username, "--", "env", fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", m.uid), "playerctl", "-p", player, command).Run()
+func (m *MediaController) execPlayerCommand(player, username, command string, args ...string) error {
+	argv := append([]string{"-u", username, "--", "env", fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", m.uid), "playerctl", "-p", player, command}, args...)
+	out, err := exec.Command("runuser", argv...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("playerctl %s: %s", command, msg)
+		}
+		return fmt.Errorf("playerctl %s: %w", command, err)
+	}
+	return nil
 }
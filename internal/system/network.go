@@ -0,0 +1,520 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nex-server/internal/models"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	nmDest             = "org.freedesktop.NetworkManager"
+	nmPath             = "/org/freedesktop/NetworkManager"
+	nmSettingsPath     = "/org/freedesktop/NetworkManager/Settings"
+	nmDeviceIface      = "org.freedesktop.NetworkManager.Device"
+	nmWirelessIface    = "org.freedesktop.NetworkManager.Device.Wireless"
+	nmAccessPointIface = "org.freedesktop.NetworkManager.AccessPoint"
+	nmActiveIface      = "org.freedesktop.NetworkManager.Connection.Active"
+	nmDeviceTypeWifi   = 2
+)
+
+const (
+	apFlagPrivacy     = 0x1
+	apSecKeyMgmt8021X = 0x200
+	apSecKeyMgmtSAE   = 0x400
+	apSecKeyMgmtOWE   = 0x800
+)
+
+var nmActiveStates = []string{"unknown", "activating", "activated", "deactivating", "deactivated"}
+
+// scanTimeout bounds the wait for a requested scan, the previous results are
+// listed when it expires.
+const scanTimeout = 10 * time.Second
+
+type NetworkMonitor struct {
+	conn *dbus.Conn
+}
+
+func NewNetworkMonitor() *NetworkMonitor {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return &NetworkMonitor{}
+	}
+	return &NetworkMonitor{conn: conn}
+}
+
+func (n *NetworkMonitor) available() bool {
+	if n.conn == nil {
+		return false
+	}
+	_, err := n.conn.Object(nmDest, nmPath).GetProperty(nmDest + ".Version")
+	return err == nil
+}
+
+func (n *NetworkMonitor) State() models.WifiState {
+	if !n.available() {
+		return getWifiState()
+	}
+
+	nm := n.conn.Object(nmDest, nmPath)
+	state := models.WifiState{VPN: []models.VPNState{}}
+	if v, err := nm.GetProperty(nmDest + ".WirelessEnabled"); err == nil {
+		state.RadioEnabled, _ = v.Value().(bool)
+	}
+
+	device, ok := n.wifiDevice()
+	if ok {
+		dev := n.conn.Object(nmDest, device)
+		if v, err := dev.GetProperty(nmDeviceIface + ".Interface"); err == nil {
+			state.Interface, _ = v.Value().(string)
+		}
+		if v, err := dev.GetProperty(nmWirelessIface + ".Bitrate"); err == nil {
+			if kbit, ok := v.Value().(uint32); ok {
+				state.Bitrate = int(kbit / 1000)
+			}
+		}
+		if v, err := dev.GetProperty(nmWirelessIface + ".ActiveAccessPoint"); err == nil {
+			if ap, ok := v.Value().(dbus.ObjectPath); ok && ap != "/" {
+				info := n.accessPoint(ap)
+				state.SSID = info.SSID
+				state.BSSID = info.BSSID
+				state.Strength = info.Strength
+				state.Frequency = info.Frequency
+				state.Band = info.Band
+				state.Security = info.Security
+				state.Connected = info.SSID != ""
+			}
+		}
+		if v, err := dev.GetProperty(nmDeviceIface + ".ActiveConnection"); err == nil {
+			if active, ok := v.Value().(dbus.ObjectPath); ok && active != "/" {
+				if id, err := n.conn.Object(nmDest, active).GetProperty(nmActiveIface + ".Id"); err == nil {
+					state.ActiveConnection, _ = id.Value().(string)
+				}
+			}
+		}
+	}
+
+	var actives []dbus.ObjectPath
+	if v, err := nm.GetProperty(nmDest + ".ActiveConnections"); err == nil {
+		actives, _ = v.Value().([]dbus.ObjectPath)
+	}
+	for _, active := range actives {
+		var props map[string]dbus.Variant
+		if err := n.conn.Object(nmDest, active).Call("org.freedesktop.DBus.Properties.GetAll", 0, nmActiveIface).Store(&props); err != nil {
+			continue
+		}
+		connType, _ := props["Type"].Value().(string)
+		isVPN, _ := props["Vpn"].Value().(bool)
+		if !isVPN && connType != "wireguard" {
+			continue
+		}
+		vpn := models.VPNState{State: enumName(nmActiveStates, props["State"])}
+		vpn.ID, _ = props["Id"].Value().(string)
+		vpn.UUID, _ = props["Uuid"].Value().(string)
+		state.VPN = append(state.VPN, vpn)
+	}
+
+	return state
+}
+
+// Scan requests a fresh scan and lists the access points once it finished.
+// Without NetworkManager the interface is scanned with iw, which needs root.
+func (n *NetworkMonitor) Scan() (models.WifiNetworks, error) {
+	networks := models.WifiNetworks{
+		AccessPoints: []models.AccessPoint{},
+		Connections:  []models.SavedConnection{},
+	}
+	if !n.available() {
+		return scanIw(networks)
+	}
+
+	device, ok := n.wifiDevice()
+	if ok {
+		dev := n.conn.Object(nmDest, device)
+		lastScan := func() int64 {
+			v, err := dev.GetProperty(nmWirelessIface + ".LastScan")
+			if err != nil {
+				return 0
+			}
+			last, _ := v.Value().(int64)
+			return last
+		}
+
+		// RequestScan returns before the scan is done, LastScan changes
+		// when the new results are in. A refused request (NetworkManager
+		// rate limits scans) lists the results of the last one.
+		before := lastScan()
+		if dev.Call(nmWirelessIface+".RequestScan", 0, map[string]dbus.Variant{}).Err == nil {
+			for deadline := time.Now().Add(scanTimeout); lastScan() == before && time.Now().Before(deadline); {
+				time.Sleep(250 * time.Millisecond)
+			}
+		}
+
+		var activeAP dbus.ObjectPath
+		if v, err := dev.GetProperty(nmWirelessIface + ".ActiveAccessPoint"); err == nil {
+			activeAP, _ = v.Value().(dbus.ObjectPath)
+		}
+
+		var aps []dbus.ObjectPath
+		dev.Call(nmWirelessIface+".GetAllAccessPoints", 0).Store(&aps)
+		for _, ap := range aps {
+			info := n.accessPoint(ap)
+			if info.SSID == "" {
+				continue
+			}
+			info.Active = ap == activeAP
+			networks.AccessPoints = append(networks.AccessPoints, info)
+		}
+		sort.SliceStable(networks.AccessPoints, func(i, j int) bool {
+			return networks.AccessPoints[i].Strength > networks.AccessPoints[j].Strength
+		})
+	}
+
+	for _, saved := range n.savedConnections() {
+		networks.Connections = append(networks.Connections, saved.SavedConnection)
+	}
+
+	return networks, nil
+}
+
+func (n *NetworkMonitor) SetWifiEnabled(enabled bool) error {
+	if !n.available() {
+		return errors.New("NetworkManager is not running")
+	}
+	return n.conn.Object(nmDest, nmPath).SetProperty(nmDest+".WirelessEnabled", dbus.MakeVariant(enabled))
+}
+
+// Connect activates a saved connection, looked up by id or uuid. Wi-Fi
+// connections are bound to the wireless device, anything else (VPN,
+// wireguard) lets NetworkManager pick.
+func (n *NetworkMonitor) Connect(name string) error {
+	if !n.available() {
+		return errors.New("NetworkManager is not running")
+	}
+
+	saved, ok := n.findConnection(name)
+	if !ok {
+		return errors.New("unknown connection")
+	}
+
+	device := dbus.ObjectPath("/")
+	if saved.Type == "802-11-wireless" {
+		if wifi, ok := n.wifiDevice(); ok {
+			device = wifi
+		}
+	}
+
+	return n.conn.Object(nmDest, nmPath).Call(nmDest+".ActivateConnection", 0, saved.path, device, dbus.ObjectPath("/")).Err
+}
+
+func (n *NetworkMonitor) Disconnect(name string) error {
+	if !n.available() {
+		return errors.New("NetworkManager is not running")
+	}
+
+	nm := n.conn.Object(nmDest, nmPath)
+	var actives []dbus.ObjectPath
+	if v, err := nm.GetProperty(nmDest + ".ActiveConnections"); err == nil {
+		actives, _ = v.Value().([]dbus.ObjectPath)
+	}
+
+	for _, active := range actives {
+		obj := n.conn.Object(nmDest, active)
+		id, _ := obj.GetProperty(nmActiveIface + ".Id")
+		uuid, _ := obj.GetProperty(nmActiveIface + ".Uuid")
+		if id.Value() == name || uuid.Value() == name {
+			return nm.Call(nmDest+".DeactivateConnection", 0, active).Err
+		}
+	}
+
+	return errors.New("connection is not active")
+}
+
+func (n *NetworkMonitor) wifiDevice() (dbus.ObjectPath, bool) {
+	var devices []dbus.ObjectPath
+	if err := n.conn.Object(nmDest, nmPath).Call(nmDest+".GetDevices", 0).Store(&devices); err != nil {
+		return "", false
+	}
+	for _, device := range devices {
+		v, err := n.conn.Object(nmDest, device).GetProperty(nmDeviceIface + ".DeviceType")
+		if err != nil {
+			continue
+		}
+		if t, ok := v.Value().(uint32); ok && t == nmDeviceTypeWifi {
+			return device, true
+		}
+	}
+	return "", false
+}
+
+func (n *NetworkMonitor) accessPoint(path dbus.ObjectPath) models.AccessPoint {
+	var props map[string]dbus.Variant
+	if err := n.conn.Object(nmDest, path).Call("org.freedesktop.DBus.Properties.GetAll", 0, nmAccessPointIface).Store(&props); err != nil {
+		return models.AccessPoint{}
+	}
+
+	ssid, _ := props["Ssid"].Value().([]byte)
+	bssid, _ := props["HwAddress"].Value().(string)
+	strength, _ := props["Strength"].Value().(byte)
+	freq, _ := props["Frequency"].Value().(uint32)
+	flags, _ := props["Flags"].Value().(uint32)
+	wpa, _ := props["WpaFlags"].Value().(uint32)
+	rsn, _ := props["RsnFlags"].Value().(uint32)
+
+	return models.AccessPoint{
+		SSID:      string(ssid),
+		BSSID:     bssid,
+		Strength:  int(strength),
+		Frequency: int(freq),
+		Band:      wifiBand(int(freq)),
+		Security:  wifiSecurity(flags, wpa, rsn),
+	}
+}
+
+type savedConnection struct {
+	models.SavedConnection
+	path dbus.ObjectPath
+}
+
+func (n *NetworkMonitor) savedConnections() []savedConnection {
+	var paths []dbus.ObjectPath
+	if err := n.conn.Object(nmDest, nmSettingsPath).Call(nmDest+".Settings.ListConnections", 0).Store(&paths); err != nil {
+		return nil
+	}
+
+	var saved []savedConnection
+	for _, path := range paths {
+		var settings map[string]map[string]dbus.Variant
+		if err := n.conn.Object(nmDest, path).Call(nmDest+".Settings.Connection.GetSettings", 0).Store(&settings); err != nil {
+			continue
+		}
+		conn := settings["connection"]
+		connType, _ := conn["type"].Value().(string)
+		if connType != "802-11-wireless" && connType != "vpn" && connType != "wireguard" {
+			continue
+		}
+		entry := savedConnection{path: path}
+		entry.ID, _ = conn["id"].Value().(string)
+		entry.UUID, _ = conn["uuid"].Value().(string)
+		entry.Type = connType
+		saved = append(saved, entry)
+	}
+	return saved
+}
+
+func (n *NetworkMonitor) findConnection(name string) (savedConnection, bool) {
+	for _, saved := range n.savedConnections() {
+		if saved.UUID == name || saved.ID == name {
+			return saved, true
+		}
+	}
+	return savedConnection{}, false
+}
+
+func wifiBand(freq int) string {
+	switch {
+	case freq == 0:
+		return ""
+	case freq < 3000:
+		return "2.4GHz"
+	case freq < 5925:
+		return "5GHz"
+	default:
+		return "6GHz"
+	}
+}
+
+func wifiSecurity(flags, wpa, rsn uint32) string {
+	switch {
+	case rsn&apSecKeyMgmtSAE != 0:
+		return "WPA3"
+	case rsn&apSecKeyMgmtOWE != 0:
+		return "OWE"
+	case rsn&apSecKeyMgmt8021X != 0:
+		return "WPA2-Enterprise"
+	case rsn != 0:
+		return "WPA2"
+	case wpa&apSecKeyMgmt8021X != 0:
+		return "WPA-Enterprise"
+	case wpa != 0:
+		return "WPA"
+	case flags&apFlagPrivacy != 0:
+		return "WEP"
+	default:
+		return "open"
+	}
+}
+
+func scanIw(networks models.WifiNetworks) (models.WifiNetworks, error) {
+	iface := wirelessInterface()
+	if iface == "" {
+		return networks, errors.New("no wireless interface")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "iw", "dev", iface, "scan").Output()
+	if err != nil {
+		// Busy or not permitted, the cached results are better than none.
+		out, err = exec.Command("iw", "dev", iface, "scan", "dump").Output()
+		if err != nil {
+			return networks, fmt.Errorf("iw scan failed: %w", err)
+		}
+	}
+
+	networks.AccessPoints = parseIwScan(string(out))
+	sort.SliceStable(networks.AccessPoints, func(i, j int) bool {
+		return networks.AccessPoints[i].Strength > networks.AccessPoints[j].Strength
+	})
+	return networks, nil
+}
+
+// parseIwScan reads the BSS blocks of iw scan output. Hidden networks are
+// left out like with NetworkManager.
+func parseIwScan(out string) []models.AccessPoint {
+	aps := []models.AccessPoint{}
+	var ap *models.AccessPoint
+	var privacy, wpa bool
+	var rsn, auth string
+
+	finish := func() {
+		if ap == nil || ap.SSID == "" {
+			return
+		}
+		switch {
+		case strings.Contains(auth, "SAE"):
+			ap.Security = "WPA3"
+		case strings.Contains(auth, "OWE"):
+			ap.Security = "OWE"
+		case rsn != "" && strings.Contains(auth, "802.1X"):
+			ap.Security = "WPA2-Enterprise"
+		case rsn != "":
+			ap.Security = "WPA2"
+		case wpa && strings.Contains(auth, "802.1X"):
+			ap.Security = "WPA-Enterprise"
+		case wpa:
+			ap.Security = "WPA"
+		case privacy:
+			ap.Security = "WEP"
+		default:
+			ap.Security = "open"
+		}
+		aps = append(aps, *ap)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "BSS "):
+			finish()
+			bssid, _, _ := strings.Cut(strings.TrimPrefix(line, "BSS "), "(")
+			ap = &models.AccessPoint{BSSID: strings.TrimSpace(bssid), Active: strings.Contains(line, "associated")}
+			privacy, wpa, rsn, auth = false, false, "", ""
+		case ap == nil:
+		case strings.HasPrefix(trimmed, "SSID:"):
+			ap.SSID = strings.TrimSpace(strings.TrimPrefix(trimmed, "SSID:"))
+		case strings.HasPrefix(trimmed, "freq:"):
+			freq, _ := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(trimmed, "freq:")), 64)
+			ap.Frequency = int(freq)
+			ap.Band = wifiBand(ap.Frequency)
+		case strings.HasPrefix(trimmed, "signal:"):
+			fields := strings.Fields(strings.TrimPrefix(trimmed, "signal:"))
+			if len(fields) > 0 {
+				dbm, _ := strconv.ParseFloat(fields[0], 64)
+				ap.Strength = dbmToPercent(int(dbm))
+			}
+		case strings.HasPrefix(trimmed, "capability:"):
+			privacy = strings.Contains(trimmed, "Privacy")
+		case strings.HasPrefix(trimmed, "RSN:"):
+			rsn = trimmed
+		case strings.HasPrefix(trimmed, "WPA:"):
+			wpa = true
+		case strings.Contains(trimmed, "Authentication suites:"):
+			auth += trimmed
+		}
+	}
+	finish()
+	return aps
+}
+
+func getWifiState() models.WifiState {
+	state := models.WifiState{VPN: []models.VPNState{}}
+
+	iface := wirelessInterface()
+	if iface != "" {
+		state.Interface = iface
+		state.RadioEnabled = readSysString(filepath.Join("/sys/class/net", iface, "operstate")) != "down"
+
+		if out, err := exec.Command("iw", "dev", iface, "link").Output(); err == nil {
+			parseIwLink(string(out), &state)
+		}
+	}
+
+	if state.SSID == "" {
+		out, err := exec.Command("iwgetid", "-r").Output()
+		if err == nil {
+			state.SSID = strings.TrimSpace(string(out))
+		}
+	}
+
+	state.Connected = state.SSID != ""
+	return state
+}
+
+func wirelessInterface() string {
+	matches, _ := filepath.Glob("/sys/class/net/*/wireless")
+	if len(matches) == 0 {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(matches[0]))
+}
+
+func parseIwLink(out string, state *models.WifiState) {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Connected to "):
+			fields := strings.Fields(line)
+			if len(fields) >= 3 {
+				state.BSSID = fields[2]
+			}
+		case strings.HasPrefix(line, "SSID:"):
+			state.SSID = strings.TrimSpace(strings.TrimPrefix(line, "SSID:"))
+		case strings.HasPrefix(line, "freq:"):
+			freq, _ := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(line, "freq:")), 64)
+			state.Frequency = int(freq)
+			state.Band = wifiBand(state.Frequency)
+		case strings.HasPrefix(line, "signal:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "signal:"))
+			if len(fields) > 0 {
+				dbm, _ := strconv.Atoi(fields[0])
+				state.Strength = dbmToPercent(dbm)
+			}
+		case strings.HasPrefix(line, "tx bitrate:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "tx bitrate:"))
+			if len(fields) > 0 {
+				rate, _ := strconv.ParseFloat(fields[0], 64)
+				state.Bitrate = int(rate)
+			}
+		}
+	}
+}
+
+func dbmToPercent(dbm int) int {
+	pct := 2 * (dbm + 100)
+	if pct < 0 {
+		return 0
+	}
+	if pct > 100 {
+		return 100
+	}
+	return pct
+}
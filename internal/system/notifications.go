@@ -0,0 +1,286 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	notificationsDest  = "org.freedesktop.Notifications"
+	notificationsPath  = "/org/freedesktop/Notifications"
+	notificationsIface = "org.freedesktop.Notifications"
+	notifyReplyTimeout = 2 * time.Second
+)
+
+var notificationUrgencies = []string{"low", "normal", "critical"}
+
+var notificationCloseReasons = map[uint32]string{
+	1: "expired",
+	2: "dismissed",
+	3: "closed",
+}
+
+// callKey identifies a Notify call. Serials are only unique per
+// connection, the reply is addressed back to the sender.
+type callKey struct {
+	sender string
+	serial uint32
+}
+
+type pendingNotification struct {
+	notification models.Notification
+	sender       string
+	seen         time.Time
+}
+
+// NotificationMonitor mirrors the desktop notifications of the real user.
+// Notify calls are observed with a monitor connection (a connection that
+// called BecomeMonitor cannot send anything), while a second, regular
+// connection is used to close, invoke and send notifications.
+type NotificationMonitor struct {
+	conn    *dbus.Conn
+	monitor *dbus.Conn
+	emit    func(event string, payload interface{})
+
+	mu      sync.Mutex
+	pending map[callKey]*pendingNotification
+	senders map[uint32]string
+}
+
+func NewNotificationMonitor(emit func(event string, payload interface{})) *NotificationMonitor {
+	n := &NotificationMonitor{
+		emit:    emit,
+		pending: make(map[callKey]*pendingNotification),
+		senders: make(map[uint32]string),
+	}
+
+	conn, err := dbus.Connect(sessionBusAddress())
+	if err != nil {
+		return n
+	}
+	n.conn = conn
+
+	if config.Current.Notifications.DisableMirror {
+		return n
+	}
+
+	monitor, err := dbus.Connect(sessionBusAddress())
+	if err != nil {
+		return n
+	}
+
+	rules := []string{
+		"type='method_call',interface='org.freedesktop.Notifications',member='Notify'",
+		"type='signal',interface='org.freedesktop.Notifications'",
+	}
+	var daemon string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, notificationsDest).Store(&daemon); err == nil {
+		rules = append(rules, fmt.Sprintf("type='method_return',sender='%s'", daemon))
+	}
+
+	if err := monitor.BusObject().Call("org.freedesktop.DBus.Monitoring.BecomeMonitor", 0, rules, uint32(0)).Err; err != nil {
+		monitor.Close()
+		return n
+	}
+	n.monitor = monitor
+
+	messages := make(chan *dbus.Message, 64)
+	monitor.Eavesdrop(messages)
+	go n.watch(messages, daemon != "")
+
+	return n
+}
+
+func (n *NotificationMonitor) watch(messages chan *dbus.Message, expectReplies bool) {
+	ticker := time.NewTicker(notifyReplyTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch msg.Type {
+			case dbus.TypeMethodCall:
+				n.handleNotify(msg, expectReplies)
+			case dbus.TypeMethodReply:
+				n.handleReply(msg)
+			case dbus.TypeSignal:
+				n.handleSignal(msg)
+			}
+		case <-ticker.C:
+			n.flushStale()
+		}
+	}
+}
+
+func (n *NotificationMonitor) handleNotify(msg *dbus.Message, expectReplies bool) {
+	if len(msg.Body) < 8 {
+		return
+	}
+
+	notification := models.Notification{Urgency: "normal", Actions: []models.NotificationAction{}, Timestamp: time.Now().Unix()}
+	notification.App, _ = msg.Body[0].(string)
+	notification.ID, _ = msg.Body[1].(uint32)
+	notification.Icon, _ = msg.Body[2].(string)
+	notification.Summary, _ = msg.Body[3].(string)
+	notification.Body, _ = msg.Body[4].(string)
+
+	if actions, ok := msg.Body[5].([]string); ok {
+		for i := 0; i+1 < len(actions); i += 2 {
+			notification.Actions = append(notification.Actions, models.NotificationAction{Key: actions[i], Label: actions[i+1]})
+		}
+	}
+	if hints, ok := msg.Body[6].(map[string]dbus.Variant); ok {
+		if urgency, ok := hints["urgency"].Value().(byte); ok && int(urgency) < len(notificationUrgencies) {
+			notification.Urgency = notificationUrgencies[urgency]
+		}
+	}
+
+	sender, _ := msg.Headers[dbus.FieldSender].Value().(string)
+	if names := n.conn.Names(); len(names) > 0 && sender == names[0] {
+		return
+	}
+
+	// The id of a new notification is only known from the daemon's reply.
+	if notification.ID != 0 || !expectReplies {
+		n.publish(notification, sender)
+		return
+	}
+
+	n.mu.Lock()
+	n.pending[callKey{sender: sender, serial: msg.Serial()}] = &pendingNotification{notification: notification, sender: sender, seen: time.Now()}
+	n.mu.Unlock()
+}
+
+func (n *NotificationMonitor) handleReply(msg *dbus.Message) {
+	serial, ok := msg.Headers[dbus.FieldReplySerial].Value().(uint32)
+	if !ok {
+		return
+	}
+	destination, _ := msg.Headers[dbus.FieldDestination].Value().(string)
+	key := callKey{sender: destination, serial: serial}
+
+	n.mu.Lock()
+	pending, ok := n.pending[key]
+	delete(n.pending, key)
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if len(msg.Body) > 0 {
+		pending.notification.ID, _ = msg.Body[0].(uint32)
+	}
+	n.publish(pending.notification, pending.sender)
+}
+
+func (n *NotificationMonitor) handleSignal(msg *dbus.Message) {
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+	if member != "NotificationClosed" || len(msg.Body) < 2 {
+		return
+	}
+
+	id, _ := msg.Body[0].(uint32)
+	reason, _ := msg.Body[1].(uint32)
+
+	n.mu.Lock()
+	delete(n.senders, id)
+	n.mu.Unlock()
+
+	closed := models.NotificationClosed{ID: id, Reason: notificationCloseReasons[reason]}
+	if closed.Reason == "" {
+		closed.Reason = "unknown"
+	}
+	n.emit("notification-closed", closed)
+}
+
+func (n *NotificationMonitor) flushStale() {
+	n.mu.Lock()
+	var stale []*pendingNotification
+	for key, pending := range n.pending {
+		if time.Since(pending.seen) > notifyReplyTimeout {
+			stale = append(stale, pending)
+			delete(n.pending, key)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, pending := range stale {
+		n.publish(pending.notification, pending.sender)
+	}
+}
+
+func (n *NotificationMonitor) publish(notification models.Notification, sender string) {
+	if notification.ID != 0 {
+		n.mu.Lock()
+		n.senders[notification.ID] = sender
+		n.mu.Unlock()
+	}
+	n.emit("notification", notification)
+}
+
+func (n *NotificationMonitor) Dismiss(id uint32) error {
+	if n.conn == nil {
+		return errors.New("session bus unavailable")
+	}
+	return n.conn.Object(notificationsDest, notificationsPath).Call(notificationsIface+".CloseNotification", 0, id).Err
+}
+
+// InvokeAction replays the ActionInvoked signal directly to the application
+// that created the notification, then closes it. The specification has no
+// client-side way to trigger an action, so this is best effort: applications
+// that only accept the signal from the notification daemon will ignore it.
+func (n *NotificationMonitor) InvokeAction(id uint32, key string) error {
+	if n.conn == nil {
+		return errors.New("session bus unavailable")
+	}
+
+	n.mu.Lock()
+	sender, ok := n.senders[id]
+	n.mu.Unlock()
+	if !ok || sender == "" {
+		return errors.New("unknown notification")
+	}
+
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:        dbus.MakeVariant(dbus.ObjectPath(notificationsPath)),
+			dbus.FieldInterface:   dbus.MakeVariant(notificationsIface),
+			dbus.FieldMember:      dbus.MakeVariant("ActionInvoked"),
+			dbus.FieldDestination: dbus.MakeVariant(sender),
+			dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(id, key)),
+		},
+		Body: []interface{}{id, key},
+	}
+	if call := n.conn.Send(msg, nil); call.Err != nil {
+		return call.Err
+	}
+
+	return n.Dismiss(id)
+}
+
+func (n *NotificationMonitor) Send(summary, body, urgency string) error {
+	if n.conn == nil {
+		return errors.New("session bus unavailable")
+	}
+
+	level := byte(1)
+	for i, name := range notificationUrgencies {
+		if name == urgency {
+			level = byte(i)
+		}
+	}
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(level)}
+
+	return n.conn.Object(notificationsDest, notificationsPath).Call(notificationsIface+".Notify", 0,
+		"Nex Viewer", uint32(0), "phone", summary, body, []string{}, hints, int32(-1)).Err
+}
@@ -0,0 +1,156 @@
+package system
+
+import (
+	"errors"
+	"nex-server/internal/models"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const powerCapabilityTTL = time.Minute
+
+var ErrUnknownPowerAction = errors.New("unknown power action")
+
+var powerMethods = map[string]string{
+	"suspend":      "Suspend",
+	"hibernate":    "Hibernate",
+	"hybrid-sleep": "HybridSleep",
+	"reboot":       "Reboot",
+	"power-off":    "PowerOff",
+}
+
+type PowerController struct {
+	conn *dbus.Conn
+
+	mu           sync.Mutex
+	capabilities map[string]string
+	checkedAt    time.Time
+	pending      *models.PendingPowerAction
+	timer        *time.Timer
+	keepAwake    *keepAwake
+}
+
+func NewPowerController() *PowerController {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return &PowerController{}
+	}
+	return &PowerController{conn: conn}
+}
+
+func (p *PowerController) State() models.PowerState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.capabilities == nil || time.Since(p.checkedAt) > powerCapabilityTTL {
+		p.capabilities = p.readCapabilities()
+		p.checkedAt = time.Now()
+	}
+
+	state := models.PowerState{
+		Capabilities: p.capabilities,
+		KeepAwake:    p.keepAwakeState(),
+		Inhibitors:   p.inhibitors(),
+	}
+	if p.pending != nil {
+		pending := *p.pending
+		state.Pending = &pending
+	}
+	return state
+}
+
+// readCapabilities mirrors logind's Can* answers: "yes", "no", "challenge"
+// (needs authentication) or "na" (not supported by the hardware).
+func (p *PowerController) readCapabilities() map[string]string {
+	caps := map[string]string{"lock": "na"}
+	for action := range powerMethods {
+		caps[action] = "na"
+	}
+	if p.conn == nil {
+		return caps
+	}
+
+	manager := p.conn.Object(login1Dest, login1Path)
+	for action, method := range powerMethods {
+		var answer string
+		if err := manager.Call(login1Manager+".Can"+method, 0).Store(&answer); err == nil {
+			caps[action] = answer
+		}
+	}
+	if _, err := userSession(p.conn); err == nil {
+		caps["lock"] = "yes"
+	}
+	return caps
+}
+
+// Schedule runs action after delay. A zero delay runs it immediately;
+// otherwise it replaces any pending action and can be cancelled until the
+// countdown ends.
+func (p *PowerController) Schedule(action string, delay time.Duration) error {
+	if _, ok := powerMethods[action]; !ok && action != "lock" {
+		return ErrUnknownPowerAction
+	}
+	if p.conn == nil {
+		return errors.New("system bus unavailable")
+	}
+
+	if delay <= 0 {
+		p.Cancel()
+		return p.run(action)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.pending = &models.PendingPowerAction{
+		Action:    action,
+		ExecuteAt: time.Now().Add(delay).Unix(),
+	}
+	pending := p.pending
+	p.timer = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		if p.pending != pending {
+			p.mu.Unlock()
+			return
+		}
+		p.pending = nil
+		p.timer = nil
+		p.mu.Unlock()
+
+		p.run(action)
+	})
+
+	return nil
+}
+
+func (p *PowerController) Cancel() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return false
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.pending = nil
+	p.timer = nil
+	return true
+}
+
+func (p *PowerController) run(action string) error {
+	if action == "lock" {
+		session, err := userSession(p.conn)
+		if err != nil {
+			return err
+		}
+		return p.conn.Object(login1Dest, session).Call("org.freedesktop.login1.Session.Lock", 0).Err
+	}
+
+	return p.conn.Object(login1Dest, login1Path).Call(login1Manager+"."+powerMethods[action], 0, false).Err
+}
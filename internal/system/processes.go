@@ -0,0 +1,145 @@
+package system
+
+import (
+	"errors"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+var signals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+type ProcessMonitor struct {
+	mu    sync.Mutex
+	procs map[int32]*process.Process
+}
+
+type processSample struct {
+	proc *process.Process
+	cpu  float64
+	rss  uint64
+}
+
+func NewProcessMonitor() *ProcessMonitor {
+	return &ProcessMonitor{
+		procs: make(map[int32]*process.Process),
+	}
+}
+
+// Top keeps the process handles between calls so that Percent(0) reports
+// the CPU usage since the previous broadcast instead of since boot.
+func (p *ProcessMonitor) Top() models.ProcessStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := config.Current.Processes.TopCount
+	if n <= 0 {
+		n = 5
+	}
+
+	pids, err := process.Pids()
+	if err != nil {
+		return models.ProcessStats{TopCPU: []models.ProcessInfo{}, TopMemory: []models.ProcessInfo{}}
+	}
+
+	alive := make(map[int32]*process.Process, len(pids))
+	samples := make([]processSample, 0, len(pids))
+	for _, pid := range pids {
+		proc, ok := p.procs[pid]
+		if !ok {
+			proc, err = process.NewProcess(pid)
+			if err != nil {
+				continue
+			}
+		}
+		alive[pid] = proc
+
+		cpu, err := proc.Percent(0)
+		if err != nil {
+			continue
+		}
+		var rss uint64
+		if mem, err := proc.MemoryInfo(); err == nil {
+			rss = mem.RSS
+		}
+		samples = append(samples, processSample{proc: proc, cpu: cpu, rss: rss})
+	}
+	p.procs = alive
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].cpu > samples[j].cpu })
+	topCPU := describeProcesses(samples, n)
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].rss > samples[j].rss })
+	topMemory := describeProcesses(samples, n)
+
+	return models.ProcessStats{
+		TopCPU:    topCPU,
+		TopMemory: topMemory,
+	}
+}
+
+func describeProcesses(samples []processSample, n int) []models.ProcessInfo {
+	if len(samples) < n {
+		n = len(samples)
+	}
+
+	infos := make([]models.ProcessInfo, 0, n)
+	for _, sample := range samples[:n] {
+		name, _ := sample.proc.Name()
+		user, _ := sample.proc.Username()
+		cmdline, _ := sample.proc.Cmdline()
+		threads, _ := sample.proc.NumThreads()
+		status, _ := sample.proc.Status()
+
+		infos = append(infos, models.ProcessInfo{
+			PID:      sample.proc.Pid,
+			Name:     name,
+			User:     user,
+			Cmdline:  cmdline,
+			CPU:      ToFixed(sample.cpu, 1),
+			RSSBytes: sample.rss,
+			Threads:  threads,
+			State:    strings.Join(status, ","),
+		})
+	}
+	return infos
+}
+
+func checkSignalTarget(pid int32) error {
+	if pid <= 1 || int(pid) == os.Getpid() {
+		return errors.New("refusing to touch this process")
+	}
+	return nil
+}
+
+func SignalProcess(pid int32, name string) error {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return errors.New("unsupported signal")
+	}
+	if err := checkSignalTarget(pid); err != nil {
+		return err
+	}
+	return syscall.Kill(int(pid), sig)
+}
+
+func ReniceProcess(pid int32, nice int) error {
+	if nice < -20 || nice > 19 {
+		return errors.New("nice value out of range")
+	}
+	if err := checkSignalTarget(pid); err != nil {
+		return err
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, int(pid), nice)
+}
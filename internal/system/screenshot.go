@@ -0,0 +1,290 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	portalDest       = "org.freedesktop.portal.Desktop"
+	portalPath       = "/org/freedesktop/portal/desktop"
+	portalRequestNS  = "/org/freedesktop/portal/desktop/request"
+	portalTimeout    = 30 * time.Second
+	defaultJPEGScore = 85
+)
+
+type monitorGeometry struct {
+	name string
+	rect image.Rectangle
+}
+
+// CaptureScreenshot grabs the desktop of the real user through the
+// xdg-desktop-portal, falling back to grim (wlroots) and then X11. The
+// encoded image is written to System.TmpDirectory and its path returned.
+func CaptureScreenshot(opts models.ScreenshotRequest) (string, string, error) {
+	if opts.Format == "" {
+		opts.Format = "png"
+	}
+	if opts.Format == "jpg" {
+		opts.Format = "jpeg"
+	}
+	if opts.Format != "png" && opts.Format != "jpeg" {
+		return "", "", errors.New("unsupported format")
+	}
+
+	cropped := false
+	img, err := capturePortal()
+	if err != nil {
+		img, cropped, err = captureGrim(opts.Monitor)
+	}
+	if err != nil {
+		img, err = captureX11()
+	}
+	if err != nil {
+		return "", "", errors.New("no screenshot backend available")
+	}
+
+	if opts.Monitor != "" && !cropped {
+		geometry, ok := findMonitor(opts.Monitor)
+		if !ok {
+			return "", "", errors.New("unknown monitor")
+		}
+		img = cropImage(img, geometry.rect)
+	}
+
+	if opts.Width > 0 && opts.Width < img.Bounds().Dx() {
+		img = scaleImage(img, opts.Width)
+	}
+
+	dir := config.Current.System.TmpDirectory
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("screenshot-%s.%s", uuid.New().String(), opts.Format))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	if opts.Format == "jpeg" {
+		quality := opts.Quality
+		if quality <= 0 || quality > 100 {
+			quality = defaultJPEGScore
+		}
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", "", err
+	}
+
+	return path, "image/" + opts.Format, nil
+}
+
+func captureGrim(monitor string) (image.Image, bool, error) {
+	if waylandDisplay() == "" {
+		return nil, false, errors.New("not a wayland session")
+	}
+
+	args := []string{}
+	if monitor != "" {
+		args = append(args, "-o", monitor)
+	}
+	args = append(args, "-t", "png", "-")
+
+	out, err := graphicalCommand(true, "grim", args...).Output()
+	if err != nil {
+		return nil, false, err
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	return img, monitor != "", err
+}
+
+func capturePortal() (image.Image, error) {
+	conn, err := dbus.Connect(sessionBusAddress())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.portal.Request"),
+		dbus.WithMatchMember("Response"),
+		dbus.WithMatchPathNamespace(portalRequestNS),
+	); err != nil {
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+
+	token := "nex" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	options := map[string]dbus.Variant{
+		"handle_token": dbus.MakeVariant(token),
+		"interactive":  dbus.MakeVariant(false),
+	}
+
+	var handle dbus.ObjectPath
+	err = conn.Object(portalDest, portalPath).Call("org.freedesktop.portal.Screenshot.Screenshot", 0, "", options).Store(&handle)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(portalTimeout)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != handle || len(sig.Body) < 2 {
+				continue
+			}
+			if code, _ := sig.Body[0].(uint32); code != 0 {
+				return nil, errors.New("screenshot request was denied")
+			}
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			uri, _ := results["uri"].Value().(string)
+			return loadPortalImage(uri)
+		case <-timeout:
+			return nil, errors.New("screenshot portal timed out")
+		}
+	}
+}
+
+// loadPortalImage reads the file the portal saved in the user's pictures
+// folder and removes it, the copy the caller gets lives in TmpDirectory.
+func loadPortalImage(uri string) (image.Image, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return nil, errors.New("invalid screenshot uri")
+	}
+	defer os.Remove(parsed.Path)
+
+	f, err := os.Open(parsed.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func captureX11() (image.Image, error) {
+	out, err := graphicalCommand(false, "import", "-silent", "-window", "root", "png:-").Output()
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+func findMonitor(name string) (monitorGeometry, bool) {
+	for _, monitor := range listMonitors() {
+		if monitor.name == name {
+			return monitor, true
+		}
+	}
+	return monitorGeometry{}, false
+}
+
+// listMonitors parses `xrandr --listmonitors`, whose lines look like
+// " 0: +*eDP-1 1920/344x1080/193+0+0  eDP-1". Under Wayland this goes
+// through Xwayland, which mirrors the compositor layout.
+func listMonitors() []monitorGeometry {
+	out, err := graphicalCommand(false, "xrandr", "--listmonitors").Output()
+	if err != nil {
+		return nil
+	}
+
+	var monitors []monitorGeometry
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+
+		var w, h, x, y int
+		geometry := strings.NewReplacer("/", " ", "x", " ", "+", " ").Replace(fields[2])
+		parts := strings.Fields(geometry)
+		if len(parts) != 6 {
+			continue
+		}
+		w, _ = strconv.Atoi(parts[0])
+		h, _ = strconv.Atoi(parts[2])
+		x, _ = strconv.Atoi(parts[4])
+		y, _ = strconv.Atoi(parts[5])
+
+		monitors = append(monitors, monitorGeometry{
+			name: fields[len(fields)-1],
+			rect: image.Rect(x, y, x+w, y+h),
+		})
+	}
+	return monitors
+}
+
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// scaleImage downsizes img to width using a box filter, averaging every
+// source pixel that falls into each destination pixel.
+func scaleImage(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	height := src.Dy() * width / src.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := 0; dy < height; dy++ {
+		y0 := src.Min.Y + dy*src.Dy()/height
+		y1 := src.Min.Y + (dy+1)*src.Dy()/height
+		for dx := 0; dx < width; dx++ {
+			x0 := src.Min.X + dx*src.Dx()/width
+			x1 := src.Min.X + (dx+1)*src.Dx()/width
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := img.At(x, y).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(dx, dy, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,210 @@
+package system
+
+import (
+	"fmt"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+const hwmonRoot = "/sys/class/hwmon"
+
+var cpuSensorChips = []string{"coretemp", "k10temp", "zenpower", "cpu_thermal", "acpitz"}
+
+func getSensors() []models.SensorChip {
+	entries, err := os.ReadDir(hwmonRoot)
+	if err != nil {
+		return []models.SensorChip{}
+	}
+
+	chips := []models.SensorChip{}
+	for _, entry := range entries {
+		dir := filepath.Join(hwmonRoot, entry.Name())
+		name := readSysString(filepath.Join(dir, "name"))
+		if name == "" {
+			continue
+		}
+
+		chip := models.SensorChip{
+			Name:         name,
+			Device:       hwmonDevice(dir),
+			Temperatures: []models.TemperatureSensor{},
+			Fans:         []models.FanSensor{},
+			Voltages:     []models.VoltageSensor{},
+		}
+
+		for _, idx := range hwmonIndexes(dir, "temp") {
+			prefix := filepath.Join(dir, fmt.Sprintf("temp%d", idx))
+			current, ok := readSysInt(prefix + "_input")
+			if !ok {
+				continue
+			}
+			high, _ := readSysInt(prefix + "_max")
+			crit, _ := readSysInt(prefix + "_crit")
+			chip.Temperatures = append(chip.Temperatures, models.TemperatureSensor{
+				Label:    hwmonLabel(prefix, fmt.Sprintf("temp%d", idx)),
+				Current:  float64(current) / 1000,
+				High:     float64(high) / 1000,
+				Critical: float64(crit) / 1000,
+			})
+		}
+
+		for _, idx := range hwmonIndexes(dir, "fan") {
+			prefix := filepath.Join(dir, fmt.Sprintf("fan%d", idx))
+			rpm, ok := readSysInt(prefix + "_input")
+			if !ok {
+				continue
+			}
+			min, _ := readSysInt(prefix + "_min")
+			max, _ := readSysInt(prefix + "_max")
+			chip.Fans = append(chip.Fans, models.FanSensor{
+				Label: hwmonLabel(prefix, fmt.Sprintf("fan%d", idx)),
+				RPM:   int(rpm),
+				Min:   int(min),
+				Max:   int(max),
+			})
+		}
+
+		for _, idx := range hwmonIndexes(dir, "in") {
+			prefix := filepath.Join(dir, fmt.Sprintf("in%d", idx))
+			mv, ok := readSysInt(prefix + "_input")
+			if !ok {
+				continue
+			}
+			min, _ := readSysInt(prefix + "_min")
+			max, _ := readSysInt(prefix + "_max")
+			chip.Voltages = append(chip.Voltages, models.VoltageSensor{
+				Label: hwmonLabel(prefix, fmt.Sprintf("in%d", idx)),
+				Volts: float64(mv) / 1000,
+				Min:   float64(min) / 1000,
+				Max:   float64(max) / 1000,
+			})
+		}
+
+		if len(chip.Temperatures) == 0 && len(chip.Fans) == 0 && len(chip.Voltages) == 0 {
+			continue
+		}
+		chips = append(chips, chip)
+	}
+
+	sort.SliceStable(chips, func(i, j int) bool {
+		return chips[i].Name < chips[j].Name
+	})
+
+	return chips
+}
+
+// hwmonIndexes returns the sorted channel numbers of every <kind>N_input
+// attribute in a hwmon directory. Channels are not guaranteed to be
+// contiguous, e.g. coretemp starts at temp1 for the package and skips ids.
+func hwmonIndexes(dir, kind string) []int {
+	matches, _ := filepath.Glob(filepath.Join(dir, kind+"*_input"))
+	var indexes []int
+	for _, match := range matches {
+		base := filepath.Base(match)
+		num := strings.TrimSuffix(strings.TrimPrefix(base, kind), "_input")
+		if idx, err := strconv.Atoi(num); err == nil {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func hwmonLabel(prefix, fallback string) string {
+	if label := readSysString(prefix + "_label"); label != "" {
+		return label
+	}
+	return fallback
+}
+
+func hwmonDevice(dir string) string {
+	target, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+func readSysString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readSysInt(path string) (int64, bool) {
+	str := readSysString(path)
+	if str == "" {
+		return 0, false
+	}
+	val, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
+
+func getCpuTemp(chips []models.SensorChip) float64 {
+	for _, name := range cpuSensorChips {
+		for _, chip := range chips {
+			if chip.Name != name || len(chip.Temperatures) == 0 {
+				continue
+			}
+			if config.Current.Sensors.PrimaryCPUTemp == "max_core" {
+				return maxCoreTemp(chip)
+			}
+			return packageTemp(chip)
+		}
+	}
+
+	temps, err := host.SensorsTemperatures()
+	if err != nil {
+		return 0.0
+	}
+	for _, temp := range temps {
+		if strings.HasPrefix(temp.SensorKey, "coretemp") || strings.HasPrefix(temp.SensorKey, "k10temp") {
+			return temp.Temperature
+		}
+	}
+	if len(temps) > 0 {
+		return temps[0].Temperature
+	}
+	return 0.0
+}
+
+func packageTemp(chip models.SensorChip) float64 {
+	for _, temp := range chip.Temperatures {
+		label := strings.ToLower(temp.Label)
+		if strings.HasPrefix(label, "package") || label == "tctl" || label == "tdie" {
+			return temp.Current
+		}
+	}
+	return chip.Temperatures[0].Current
+}
+
+func maxCoreTemp(chip models.SensorChip) float64 {
+	max := 0.0
+	found := false
+	for _, temp := range chip.Temperatures {
+		label := strings.ToLower(temp.Label)
+		if !strings.HasPrefix(label, "core") && !strings.HasPrefix(label, "tccd") {
+			continue
+		}
+		if !found || temp.Current > max {
+			max = temp.Current
+			found = true
+		}
+	}
+	if !found {
+		return packageTemp(chip)
+	}
+	return max
+}
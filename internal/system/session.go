@@ -0,0 +1,88 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func sessionBusAddress() string {
+	return fmt.Sprintf("unix:path=/run/user/%d/bus", getRealUserID())
+}
+
+// userCommand runs a command as the real user with their runtime dir, so
+// that it talks to their PipeWire/PulseAudio and compositor instead of root's.
+func userCommand(name string, args ...string) *exec.Cmd {
+	uid := getRealUID()
+
+	var cmd *exec.Cmd
+	if os.Getuid() == uid {
+		cmd = exec.Command(name, args...)
+	} else {
+		cmd = exec.Command("runuser", append([]string{"-u", getUsernameFromUID(uid), "--", name}, args...)...)
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", uid))
+	return cmd
+}
+
+func userOutput(name string, args ...string) string {
+	out, err := userCommand(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// graphicalCommand runs a tool in the desktop user's graphical
+// session, picking the Wayland socket when there is one and X11 otherwise.
+func graphicalCommand(wayland bool, name string, args ...string) *exec.Cmd {
+	cmd := userCommand(name, args...)
+	if wayland {
+		cmd.Env = append(cmd.Env, "WAYLAND_DISPLAY="+waylandDisplay())
+	} else {
+		cmd.Env = append(cmd.Env, "DISPLAY=:0")
+		if xauth := xauthority(); xauth != "" {
+			cmd.Env = append(cmd.Env, "XAUTHORITY="+xauth)
+		}
+	}
+	return cmd
+}
+
+func waylandDisplay() string {
+	matches, _ := filepath.Glob(fmt.Sprintf("/run/user/%d/wayland-[0-9]", getRealUID()))
+	if len(matches) == 0 {
+		return ""
+	}
+	return filepath.Base(matches[0])
+}
+
+func xauthority() string {
+	uid := getRealUID()
+	candidates, _ := filepath.Glob(fmt.Sprintf("/run/user/%d/.mutter-Xwaylandauth.*", uid))
+	candidates = append(candidates, fmt.Sprintf("/run/user/%d/gdm/Xauthority", uid))
+	if home := userHome(uid); home != "" {
+		candidates = append(candidates, filepath.Join(home, ".Xauthority"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func userHome(uid int) string {
+	data, err := os.ReadFile("/etc/passwd")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Split(line, ":")
+		if len(parts) >= 6 && parts[2] == fmt.Sprint(uid) {
+			return parts[5]
+		}
+	}
+	return ""
+}
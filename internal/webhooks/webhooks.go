@@ -0,0 +1,352 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"nex-server/internal/config"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultRetries = 8
+	eventBuffer    = 256
+	maxBackoff     = time.Hour
+	requestTimeout = 10 * time.Second
+)
+
+// Body is what a template renders, the default body is its JSON encoding.
+type Body struct {
+	Event     string      `json:"event"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// delivery is one queued request. It is stored as a JSON file in the queue
+// directory until it succeeded or ran out of retries, so pending deliveries
+// survive a restart.
+type delivery struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Event    string    `json:"event"`
+	Body     string    `json:"body"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+}
+
+// event is a broadcast event waiting to be turned into deliveries.
+type event struct {
+	name    string
+	payload interface{}
+}
+
+// Dispatcher turns events into webhook deliveries and sends them from a
+// single worker. A failed delivery waits 2^attempts seconds (at most an
+// hour) before the next try.
+type Dispatcher struct {
+	dir    string
+	client *http.Client
+	events chan event
+
+	mu      sync.Mutex
+	queue   map[string]*delivery
+	pending chan struct{}
+}
+
+func NewDispatcher() (*Dispatcher, error) {
+	dir := config.DataPath("webhooks")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{
+		dir:     dir,
+		client:  &http.Client{Timeout: requestTimeout},
+		events:  make(chan event, eventBuffer),
+		queue:   make(map[string]*delivery),
+		pending: make(chan struct{}, 1),
+	}
+	d.load()
+	return d, nil
+}
+
+// load picks up the deliveries left over from the previous run.
+func (d *Dispatcher) load() {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var item delivery
+		if err := json.Unmarshal(data, &item); err != nil || item.ID == "" {
+			os.Remove(file)
+			continue
+		}
+		d.queue[item.ID] = &item
+	}
+}
+
+// Dispatch hands an event to the dispatcher goroutine and returns at once,
+// it is called for every broadcast event from the manager loop. Events are
+// dropped when the dispatcher falls behind.
+func (d *Dispatcher) Dispatch(name string, payload interface{}) {
+	wanted := false
+	for _, hook := range config.Current.Webhooks {
+		if hook.URL != "" && subscribed(hook, name) {
+			wanted = true
+			break
+		}
+	}
+	if !wanted {
+		return
+	}
+
+	select {
+	case d.events <- event{name: name, payload: payload}:
+	default:
+		log.Printf("Webhook event %s dropped, queue full", name)
+	}
+}
+
+// prepare renders the bodies of an event and queues a delivery for every
+// webhook subscribed to it.
+func (d *Dispatcher) prepare(e event) {
+	var data interface{}
+	for _, hook := range config.Current.Webhooks {
+		if hook.URL == "" || !subscribed(hook, e.name) {
+			continue
+		}
+
+		// The payload is passed to templates in its JSON form, so field
+		// names match the websocket events.
+		if data == nil {
+			raw, err := json.Marshal(e.payload)
+			if err != nil {
+				return
+			}
+			json.Unmarshal(raw, &data)
+		}
+
+		body, err := render(hook, Body{Event: e.name, Timestamp: time.Now().Unix(), Data: data})
+		if err != nil {
+			log.Printf("Webhook %s: %v", hook.URL, err)
+			continue
+		}
+		d.enqueue(&delivery{
+			ID:    uuid.New().String(),
+			URL:   hook.URL,
+			Event: e.name,
+			Body:  body,
+			Next:  time.Now(),
+		})
+	}
+}
+
+func subscribed(hook config.WebhookConfig, event string) bool {
+	for _, pattern := range hook.Events {
+		if matched, _ := path.Match(pattern, event); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// render executes the template of the hook. Besides the fields of Body it
+// has a json function for embedding values, e.g. {"text": {{json .Event}}}.
+func render(hook config.WebhookConfig, body Body) (string, error) {
+	if hook.Template == "" {
+		data, err := json.Marshal(body)
+		return string(data), err
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}).Parse(hook.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, body); err != nil {
+		return "", err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", fmt.Errorf("template did not render valid JSON")
+	}
+	return buf.String(), nil
+}
+
+func (d *Dispatcher) enqueue(item *delivery) {
+	d.mu.Lock()
+	d.queue[item.ID] = item
+	d.save(item)
+	d.mu.Unlock()
+
+	select {
+	case d.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) save(item *delivery) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return
+	}
+	file := filepath.Join(d.dir, item.ID+".json")
+	if err := os.WriteFile(file+".tmp", data, 0600); err != nil {
+		return
+	}
+	os.Rename(file+".tmp", file)
+}
+
+func (d *Dispatcher) remove(item *delivery) {
+	delete(d.queue, item.ID)
+	os.Remove(filepath.Join(d.dir, item.ID+".json"))
+}
+
+// Run prepares dispatched events and sends due deliveries, oldest first,
+// until the process exits. Preparing runs apart from sending, so a slow
+// receiver does not hold up new events.
+func (d *Dispatcher) Run() {
+	go func() {
+		for e := range d.events {
+			d.prepare(e)
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.pending:
+		case <-ticker.C:
+		}
+		for _, item := range d.due() {
+			d.attempt(item)
+		}
+	}
+}
+
+func (d *Dispatcher) due() []*delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	items := []*delivery{}
+	for _, item := range d.queue {
+		if !item.Next.After(now) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Next.Before(items[j].Next)
+	})
+	return items
+}
+
+func (d *Dispatcher) attempt(item *delivery) {
+	// The hook is looked up again so a changed secret or a removed hook
+	// applies to deliveries queued before the change.
+	hook, ok := webhookFor(item.URL)
+	if !ok {
+		d.mu.Lock()
+		d.remove(item)
+		d.mu.Unlock()
+		return
+	}
+
+	err := d.send(hook, item)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.remove(item)
+		return
+	}
+
+	item.Attempts++
+	retries := hook.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	if item.Attempts > retries {
+		log.Printf("Webhook %s: dropping %s after %d attempts: %v", item.URL, item.Event, item.Attempts, err)
+		d.remove(item)
+		return
+	}
+
+	// 2^12 seconds is past maxBackoff already, and shifting further
+	// overflows into a negative wait once a hook allows enough retries.
+	backoff := maxBackoff
+	if item.Attempts < 12 {
+		backoff = time.Duration(1<<item.Attempts) * time.Second
+	}
+	item.Next = time.Now().Add(backoff)
+	d.save(item)
+}
+
+func webhookFor(url string) (config.WebhookConfig, bool) {
+	for _, hook := range config.Current.Webhooks {
+		if hook.URL == url {
+			return hook, true
+		}
+	}
+	return config.WebhookConfig{}, false
+}
+
+// send posts the body once. The signature covers the raw body, receivers
+// compute HMAC-SHA256 with the shared secret and compare it to
+// X-Nex-Signature.
+func (d *Dispatcher) send(hook config.WebhookConfig, item *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, item.URL, strings.NewReader(item.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nex-server")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("X-Nex-Event", item.Event)
+	req.Header.Set("X-Nex-Delivery", item.ID)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write([]byte(item.Body))
+		req.Header.Set("X-Nex-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
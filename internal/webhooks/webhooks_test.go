@@ -0,0 +1,305 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"nex-server/internal/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stub is a local receiver answering with the queued statuses, 200 once
+// they are used up.
+type stub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+type received struct {
+	header http.Header
+	body   string
+}
+
+func newStub(t *testing.T, statuses ...int) *stub {
+	s := &stub{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, received{header: r.Header.Clone(), body: string(body)})
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stub) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received{}, s.requests...)
+}
+
+func newTestDispatcher(t *testing.T, hooks ...config.WebhookConfig) *Dispatcher {
+	t.Helper()
+
+	config.Current = &config.Config{Webhooks: hooks}
+	config.Current.System.DataDirectory = t.TempDir()
+	d, err := NewDispatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// deliverDue does one round of the Run loop.
+func deliverDue(d *Dispatcher) {
+	for _, item := range d.due() {
+		d.attempt(item)
+	}
+}
+
+func queueFiles(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(config.DataPath("webhooks"), "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSignedDelivery(t *testing.T) {
+	server := newStub(t)
+	d := newTestDispatcher(t, config.WebhookConfig{
+		URL:     server.URL,
+		Events:  []string{"alert*"},
+		Secret:  "shared-secret",
+		Headers: map[string]string{"Authorization": "Bearer xyz"},
+	})
+
+	d.prepare(event{name: "alert", payload: map[string]interface{}{"name": "CPU", "value": 93}})
+	d.prepare(event{name: "track-changed", payload: nil})
+	deliverDue(d)
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+
+	mac := hmac.New(sha256.New, []byte("shared-secret"))
+	mac.Write([]byte(req.body))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get("X-Nex-Signature") != want {
+		t.Errorf("X-Nex-Signature = %q, want %q", req.header.Get("X-Nex-Signature"), want)
+	}
+	if req.header.Get("X-Nex-Event") != "alert" || req.header.Get("X-Nex-Delivery") == "" {
+		t.Errorf("missing event headers: %v", req.header)
+	}
+	if req.header.Get("Authorization") != "Bearer xyz" {
+		t.Errorf("configured header not sent: %v", req.header)
+	}
+
+	var body Body
+	if err := json.Unmarshal([]byte(req.body), &body); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := body.Data.(map[string]interface{})
+	if body.Event != "alert" || body.Timestamp == 0 || data["name"] != "CPU" {
+		t.Errorf("unexpected body %s", req.body)
+	}
+	if files := queueFiles(t); len(files) != 0 {
+		t.Errorf("delivered item still queued: %v", files)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	server := newStub(t)
+	d := newTestDispatcher(t,
+		config.WebhookConfig{
+			URL:      server.URL + "/chat",
+			Events:   []string{"alert"},
+			Template: `{"text": {{json (printf "%s: %s is %v" .Event .Data.name .Data.value)}}}`,
+		},
+		config.WebhookConfig{
+			URL:      server.URL + "/broken",
+			Events:   []string{"alert"},
+			Template: `text: {{.Event}}`,
+		},
+	)
+
+	d.prepare(event{name: "alert", payload: map[string]interface{}{"name": "CPU", "value": 93}})
+	deliverDue(d)
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want only the valid template", len(requests))
+	}
+	if want := `{"text": "alert: CPU is 93"}`; requests[0].body != want {
+		t.Errorf("body = %s, want %s", requests[0].body, want)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	server := newStub(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	d := newTestDispatcher(t, config.WebhookConfig{URL: server.URL, Events: []string{"*"}, Retries: 2})
+
+	d.prepare(event{name: "test", payload: "hello"})
+	for attempt, backoff := range []time.Duration{2 * time.Second, 4 * time.Second} {
+		start := time.Now()
+		deliverDue(d)
+
+		items := d.due()
+		if len(items) != 0 {
+			t.Fatalf("attempt %d: retried before the backoff", attempt+1)
+		}
+		item := d.queue[onlyID(t, d)]
+		if item.Attempts != attempt+1 {
+			t.Errorf("attempts = %d, want %d", item.Attempts, attempt+1)
+		}
+		if wait := item.Next.Sub(start); wait < backoff || wait > backoff+time.Second {
+			t.Errorf("attempt %d: next try in %v, want %v", attempt+1, wait, backoff)
+		}
+		item.Next = time.Now()
+	}
+	deliverDue(d)
+
+	requests := server.received()
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(requests))
+	}
+	if requests[0].header.Get("X-Nex-Delivery") != requests[2].header.Get("X-Nex-Delivery") {
+		t.Error("retry sent with another delivery id")
+	}
+	if len(d.queue) != 0 || len(queueFiles(t)) != 0 {
+		t.Error("delivered item still queued")
+	}
+}
+
+func TestRetryBackoffLimit(t *testing.T) {
+	server := newStub(t)
+	server.statuses = make([]int, 64)
+	for i := range server.statuses {
+		server.statuses[i] = http.StatusInternalServerError
+	}
+	d := newTestDispatcher(t, config.WebhookConfig{URL: server.URL, Events: []string{"*"}, Retries: 100})
+
+	d.prepare(event{name: "test", payload: "hello"})
+	for attempts, backoff := range map[int]time.Duration{10: 2048 * time.Second, 11: maxBackoff, 33: maxBackoff, 62: maxBackoff, 70: maxBackoff} {
+		item := d.queue[onlyID(t, d)]
+		item.Attempts = attempts
+		item.Next = time.Now()
+
+		start := time.Now()
+		deliverDue(d)
+		if wait := item.Next.Sub(start); wait < backoff || wait > backoff+time.Second {
+			t.Errorf("attempt %d: next try in %v, want %v", attempts+1, wait, backoff)
+		}
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	server := newStub(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	d := newTestDispatcher(t, config.WebhookConfig{URL: server.URL, Events: []string{"*"}, Retries: 1})
+
+	d.prepare(event{name: "test", payload: "hello"})
+	deliverDue(d)
+	d.queue[onlyID(t, d)].Next = time.Now()
+	deliverDue(d)
+
+	if len(server.received()) != 2 || len(d.queue) != 0 || len(queueFiles(t)) != 0 {
+		t.Errorf("got %d requests and %d queued, want 2 and none", len(server.received()), len(d.queue))
+	}
+}
+
+func TestQueueReload(t *testing.T) {
+	server := newStub(t, http.StatusServiceUnavailable)
+	d := newTestDispatcher(t, config.WebhookConfig{URL: server.URL, Events: []string{"*"}})
+
+	d.prepare(event{name: "test", payload: "hello"})
+	deliverDue(d)
+	id := onlyID(t, d)
+	if files := queueFiles(t); len(files) != 1 {
+		t.Fatalf("queue files = %v, want one", files)
+	}
+
+	// A new dispatcher on the same directory, as after a restart.
+	restarted, err := NewDispatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := restarted.queue[id]
+	if !ok || item.Attempts != 1 || item.Event != "test" || item.URL != server.URL {
+		t.Fatalf("reloaded queue = %+v", restarted.queue)
+	}
+
+	item.Next = time.Now()
+	deliverDue(restarted)
+	requests := server.received()
+	if len(requests) != 2 || requests[1].header.Get("X-Nex-Delivery") != id {
+		t.Fatalf("reloaded delivery not sent: %d requests", len(requests))
+	}
+	if len(queueFiles(t)) != 0 {
+		t.Error("delivered item still on disk")
+	}
+}
+
+func TestCorruptQueueFile(t *testing.T) {
+	newTestDispatcher(t, config.WebhookConfig{URL: "http://127.0.0.1:1", Events: []string{"*"}})
+	file := filepath.Join(config.DataPath("webhooks"), "broken.json")
+	os.WriteFile(file, []byte("{"), 0600)
+
+	d, err := NewDispatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queue) != 0 {
+		t.Errorf("corrupt file queued: %+v", d.queue)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("corrupt file not removed")
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := newStub(t)
+	d := newTestDispatcher(t, config.WebhookConfig{URL: server.URL, Events: []string{"alert"}})
+	go d.Run()
+
+	d.Dispatch("power-source", "ignored")
+	d.Dispatch("alert", "hello")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(server.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	requests := server.received()
+	if len(requests) != 1 || requests[0].header.Get("X-Nex-Event") != "alert" {
+		t.Fatalf("got %d requests, want the alert only", len(requests))
+	}
+}
+
+func onlyID(t *testing.T, d *Dispatcher) string {
+	t.Helper()
+	if len(d.queue) != 1 {
+		t.Fatalf("queue has %d items, want 1", len(d.queue))
+	}
+	for id := range d.queue {
+		return id
+	}
+	return ""
+}
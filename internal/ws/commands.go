@@ -0,0 +1,312 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/models"
+	"nex-server/internal/system"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrForbidden      = errors.New("not allowed")
+	ErrReadOnly       = errors.New("server is read only")
+	ErrMissingArgs    = errors.New("missing arguments")
+	ErrInvalidArgs    = errors.New("invalid arguments")
+	ErrNotFound       = errors.New("not found")
+)
+
+type permission int
+
+const (
+	// anyone with a valid token
+	permitAll permission = iota
+	// refused while the server is read only
+	permitWrite
+	// admin scope and not read only
+	permitControl
+)
+
+// command is an action shared by the websocket and the REST API. refresh
+// broadcasts fresh stats after it succeeded, so clients see the effect.
+type command struct {
+	args       int
+	permission permission
+	refresh    bool
+	run        func(m *Manager, args []string) error
+}
+
+var commands = map[string]command{
+	// audio-<action> [player] is rewritten to audio [player, action].
+	"audio": {2, permitAll, true, func(m *Manager, args []string) error {
+		return m.Media.Control(args[0], args[1], args[2:]...)
+	}},
+	"media": {1, permitAll, true, func(m *Manager, args []string) error {
+		switch args[0] {
+		case "play_pause":
+			m.Media.PlayPause()
+		case "next":
+			m.Media.Next()
+		case "previous":
+			m.Media.Previous()
+		case "set_position":
+			if len(args) < 2 {
+				return ErrMissingArgs
+			}
+			pos, err := intArg(args[1])
+			if err != nil {
+				return err
+			}
+			m.Media.SetPosition(int64(pos))
+		default:
+			return ErrInvalidArgs
+		}
+		return nil
+	}},
+	"process-signal": {2, permitControl, true, func(m *Manager, args []string) error {
+		pid, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return system.SignalProcess(int32(pid), args[1])
+	}},
+	"process-renice": {2, permitControl, true, func(m *Manager, args []string) error {
+		pid, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		nice, err := intArg(args[1])
+		if err != nil {
+			return err
+		}
+		return system.ReniceProcess(int32(pid), nice)
+	}},
+	"power": {1, permitControl, true, func(m *Manager, args []string) error {
+		delay := 0
+		if len(args) > 1 {
+			fmt.Sscanf(args[1], "%d", &delay)
+		}
+		return m.Power.Schedule(args[0], time.Duration(delay)*time.Second)
+	}},
+	"power-cancel": {0, permitControl, true, func(m *Manager, args []string) error {
+		if !m.Power.Cancel() {
+			return fmt.Errorf("%w: no pending power action", ErrNotFound)
+		}
+		return nil
+	}},
+	"keep-awake": {1, permitWrite, true, func(m *Manager, args []string) error {
+		duration := 0
+		if len(args) > 1 {
+			fmt.Sscanf(args[1], "%d", &duration)
+		}
+		return m.Power.KeepAwake(args[0] == "on", time.Duration(duration)*time.Second)
+	}},
+	"notification-dismiss": {1, permitWrite, false, func(m *Manager, args []string) error {
+		id, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return m.Notifications.Dismiss(uint32(id))
+	}},
+	"notification-action": {2, permitWrite, false, func(m *Manager, args []string) error {
+		id, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return m.Notifications.InvokeAction(uint32(id), args[1])
+	}},
+	"notification-send": {1, permitWrite, false, func(m *Manager, args []string) error {
+		body, urgency := "", "normal"
+		if len(args) > 1 {
+			body = args[1]
+		}
+		if len(args) > 2 {
+			urgency = args[2]
+		}
+		return m.Notifications.Send(args[0], body, urgency)
+	}},
+	"clipboard-set": {1, permitWrite, false, func(m *Manager, args []string) error {
+		if len(args) > 1 {
+			return m.Clipboard.Set(args[0], args[1])
+		}
+		return m.Clipboard.Set("text/plain", args[0])
+	}},
+	"wifi-radio": {1, permitControl, true, func(m *Manager, args []string) error {
+		return m.Network.SetWifiEnabled(args[0] == "on")
+	}},
+	"wifi-connect": {1, permitControl, true, func(m *Manager, args []string) error {
+		return m.Network.Connect(args[0])
+	}},
+	"vpn-up": {1, permitControl, true, func(m *Manager, args []string) error {
+		return m.Network.Connect(args[0])
+	}},
+	"vpn-down": {1, permitControl, true, func(m *Manager, args []string) error {
+		return m.Network.Disconnect(args[0])
+	}},
+	"launch-app": {1, permitWrite, false, func(m *Manager, args []string) error {
+		return system.LaunchApplication(args[0])
+	}},
+	"volume-set": {1, permitWrite, true, func(m *Manager, args []string) error {
+		vol, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return system.SetVolume(vol)
+	}},
+	"volume-step": {1, permitWrite, true, func(m *Manager, args []string) error {
+		delta, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return system.StepVolume(delta)
+	}},
+	"volume-mute": {0, permitWrite, true, func(m *Manager, args []string) error {
+		return system.SetMute(optionalArg(args, 0))
+	}},
+	"mic-mute": {0, permitWrite, true, func(m *Manager, args []string) error {
+		return system.SetMicMute(optionalArg(args, 0))
+	}},
+	"sound-default-sink": {1, permitWrite, true, func(m *Manager, args []string) error {
+		return system.SetDefaultSink(args[0])
+	}},
+	"sound-default-source": {1, permitWrite, true, func(m *Manager, args []string) error {
+		return system.SetDefaultSource(args[0])
+	}},
+	"stream-volume": {2, permitWrite, true, func(m *Manager, args []string) error {
+		index, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		vol, err := intArg(args[1])
+		if err != nil {
+			return err
+		}
+		return system.SetStreamVolume(index, vol)
+	}},
+	"stream-mute": {1, permitWrite, true, func(m *Manager, args []string) error {
+		index, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return system.SetStreamMute(index, optionalArg(args, 1))
+	}},
+	"stream-move": {2, permitWrite, true, func(m *Manager, args []string) error {
+		index, err := intArg(args[0])
+		if err != nil {
+			return err
+		}
+		return system.MoveStream(index, args[1])
+	}},
+	"set-brightness": {2, permitWrite, true, func(m *Manager, args []string) error {
+		pct, err := intArg(args[1])
+		if err != nil {
+			return err
+		}
+		return system.SetBrightness(args[0], pct)
+	}},
+}
+
+// Execute runs an action for the holder of claims. It is the single entry
+// point for actions of the websocket, MQTT and the REST API, so all of them
+// share the same arguments and permission checks.
+func (m *Manager) Execute(claims *auth.Claims, event string, args []string) error {
+	if action, ok := strings.CutPrefix(event, "audio-"); ok {
+		event = "audio"
+		if len(args) > 0 {
+			args = append([]string{args[0], action}, args[1:]...)
+		}
+	}
+
+	cmd, ok := commands[event]
+	if !ok {
+		return ErrUnknownCommand
+	}
+	if len(args) < cmd.args {
+		return ErrMissingArgs
+	}
+
+	switch cmd.permission {
+	case permitControl:
+		if config.Current.ReadOnly {
+			return ErrReadOnly
+		}
+		if claims == nil || !claims.HasScope(auth.ScopeAdmin) {
+			return ErrForbidden
+		}
+	case permitWrite:
+		if config.Current.ReadOnly {
+			return ErrReadOnly
+		}
+	}
+
+	if err := cmd.run(m, args); err != nil {
+		return err
+	}
+	if cmd.refresh {
+		m.refreshStats()
+	}
+	return nil
+}
+
+// ErrorCode returns the models.APIError code of an error from Execute.
+// Errors of the action itself are internal errors.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		return models.ErrUnknownEvent
+	case errors.Is(err, ErrNotFound), errors.Is(err, system.ErrUnknownPlayer):
+		return models.ErrNotFound
+	case errors.Is(err, ErrForbidden):
+		return models.ErrForbidden
+	case errors.Is(err, ErrReadOnly):
+		return models.ErrReadOnly
+	case errors.Is(err, ErrMissingArgs), errors.Is(err, ErrInvalidArgs), errors.Is(err, system.ErrUnknownPowerAction):
+		return models.ErrInvalidArguments
+	}
+	return models.ErrInternal
+}
+
+func intArg(arg string) (int, error) {
+	var value int
+	if _, err := fmt.Sscanf(arg, "%d", &value); err != nil {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrInvalidArgs, arg)
+	}
+	return value, nil
+}
+
+func optionalArg(args []string, index int) string {
+	if index < len(args) {
+		return args[index]
+	}
+	return ""
+}
+
+// ActionInfo describes an action for the API documents.
+type ActionInfo struct {
+	Event      string
+	Args       int
+	Permission string
+}
+
+// Actions lists the actions Execute accepts, sorted by event name.
+func Actions() []ActionInfo {
+	names := map[permission]string{permitAll: "any", permitWrite: "write", permitControl: "admin"}
+
+	actions := []ActionInfo{}
+	for event, cmd := range commands {
+		info := ActionInfo{Event: event, Args: cmd.args, Permission: names[cmd.permission]}
+		if event == "audio" {
+			info.Event, info.Args = "audio-<action>", 1
+		}
+		actions = append(actions, info)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Event < actions[j].Event
+	})
+	return actions
+}
@@ -1,15 +1,25 @@
 package ws
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"nex-server/internal/alerts"
 	"nex-server/internal/auth"
+	"nex-server/internal/config"
+	"nex-server/internal/history"
+	"nex-server/internal/models"
 	"nex-server/internal/system"
-	"strings"
+	"nex-server/internal/webhooks"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -19,28 +29,78 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// Client is a websocket connection or a local transport. Send is never
+// closed, as screenshot and command goroutines may still write to it; done
+// is closed instead once the client is removed from the manager.
 type Client struct {
 	Manager       *Manager
 	Conn          *websocket.Conn
 	Send          chan []byte
 	Expiry        time.Time
 	Authenticated bool
+	Claims        *auth.Claims
+
+	done chan struct{}
 }
 
 type Manager struct {
-	Clients    map[*Client]bool
-	Register   chan *Client
-	Unregister chan *Client
-	Media      *system.MediaController
+	Clients       map[*Client]bool
+	Register      chan *Client
+	Unregister    chan *Client
+	Broadcast     chan []byte
+	refresh       chan struct{}
+	Media         *system.MediaController
+	Processes     *system.ProcessMonitor
+	Battery       *system.BatteryMonitor
+	Network       *system.NetworkMonitor
+	Power         *system.PowerController
+	Notifications *system.NotificationMonitor
+	Clipboard     *system.ClipboardMonitor
+	History       *history.Store
+	Alerts        *alerts.Engine
+	Webhooks      *webhooks.Dispatcher
+
+	statsMu        sync.RWMutex
+	latest         *models.SystemStats
+	broadcasts     uint64
+	broadcastTotal time.Duration
+	clientCount    atomic.Int64
 }
 
 func NewManager() *Manager {
-	return &Manager{
+	m := &Manager{
 		Clients:    make(map[*Client]bool),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
+		Broadcast:  make(chan []byte, 64),
+		refresh:    make(chan struct{}, 1),
 		Media:      system.NewMediaController(),
+		Processes:  system.NewProcessMonitor(),
+		Battery:    system.NewBatteryMonitor(),
+		Network:    system.NewNetworkMonitor(),
+		Power:      system.NewPowerController(),
+	}
+	m.Notifications = system.NewNotificationMonitor(m.BroadcastEvent)
+	m.Clipboard = system.NewClipboardMonitor(m.BroadcastEvent)
+	m.Alerts = alerts.NewEngine(m.BroadcastEvent, m.Notifications.Send)
+	if !config.Current.History.Disable {
+		store, err := history.Open()
+		if err != nil {
+			log.Printf("Metrics history disabled: %v", err)
+		} else {
+			m.History = store
+		}
 	}
+	if len(config.Current.Webhooks) > 0 {
+		dispatcher, err := webhooks.NewDispatcher()
+		if err != nil {
+			log.Printf("Webhooks disabled: %v", err)
+		} else {
+			m.Webhooks = dispatcher
+			go dispatcher.Run()
+		}
+	}
+	return m
 }
 
 func (m *Manager) Run() {
@@ -51,12 +111,13 @@ func (m *Manager) Run() {
 		select {
 		case client := <-m.Register:
 			m.Clients[client] = true
+			m.clientCount.Store(int64(len(m.Clients)))
 		case client := <-m.Unregister:
-			if _, ok := m.Clients[client]; ok {
-				client.Conn.Close()
-				delete(m.Clients, client)
-				close(client.Send)
-			}
+			m.remove(client)
+		case msg := <-m.Broadcast:
+			m.sendToAll(msg)
+		case <-m.refresh:
+			m.broadcastStats()
 		case <-ticker.C:
 			m.broadcastStats()
 			m.checkExpiry()
@@ -64,14 +125,129 @@ func (m *Manager) Run() {
 	}
 }
 
+// refreshStats asks the Run loop for a stats broadcast ahead of the ticker, so an
+// action shows up at once. Requests made while one is pending are merged.
+func (m *Manager) refreshStats() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
 func (m *Manager) broadcastStats() {
-	stats, err := system.GetSystemStats(m.Media)
+	start := time.Now()
+	stats := system.CollectStats(m.Media, m.Processes, m.Battery, m.Network, m.Power)
+	if m.History != nil {
+		m.History.Record(stats)
+	}
+	m.Alerts.Evaluate(stats)
+
+	event, err := system.NewStatsEvent(stats)
+	if err != nil {
+		return
+	}
+
+	msg, _ := json.Marshal(event)
+	m.sendToAll(msg)
+
+	m.statsMu.Lock()
+	previous := m.latest
+	m.latest = &stats
+	m.broadcasts++
+	m.broadcastTotal += time.Since(start)
+	m.statsMu.Unlock()
+
+	if previous != nil {
+		m.broadcastChanges(*previous, stats)
+	}
+}
+
+// broadcastChanges turns transitions between two samples into events, for
+// clients and webhooks that care about the change rather than the values.
+func (m *Manager) broadcastChanges(previous, current models.SystemStats) {
+	for _, player := range current.Audio {
+		if player.Title == "" {
+			continue
+		}
+		changed := true
+		for _, old := range previous.Audio {
+			if old.Name == player.Name {
+				changed = old.Title != player.Title || old.Artist != player.Artist
+				break
+			}
+		}
+		if changed {
+			m.BroadcastEvent("track-changed", player)
+		}
+	}
+
+	if current.Battery.Present && previous.Battery.Present && current.Battery.PluggedIn != previous.Battery.PluggedIn {
+		m.BroadcastEvent("power-source", models.PowerSource{
+			PluggedIn:  current.Battery.PluggedIn,
+			Percentage: current.Battery.Percentage,
+		})
+	}
+}
+
+// Snapshot returns the stats of the last broadcast, false before the first.
+func (m *Manager) Snapshot() (models.SystemStats, bool) {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	if m.latest == nil {
+		return models.SystemStats{}, false
+	}
+	return *m.latest, true
+}
+
+// ServerStats reports the connected clients and the time spent collecting
+// and sending stats, for the metrics endpoint.
+func (m *Manager) ServerStats() models.ServerStats {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	return models.ServerStats{
+		Clients:          int(m.clientCount.Load()),
+		Broadcasts:       m.broadcasts,
+		BroadcastSeconds: m.broadcastTotal.Seconds(),
+		CollectorErrors:  system.CollectorErrors(),
+	}
+}
+
+// BroadcastEvent queues an event for every authenticated client. It is safe
+// to call from any goroutine; delivery happens on the Run loop.
+func (m *Manager) BroadcastEvent(event string, payload interface{}) {
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return
 	}
+	msg, _ := json.Marshal(models.StatsEvent{
+		Event: event,
+		Args:  []string{string(data)},
+	})
 
-	msg, _ := json.Marshal(stats)
+	select {
+	case m.Broadcast <- msg:
+	default:
+	}
 
+	if m.Webhooks != nil {
+		m.Webhooks.Dispatch(event, payload)
+	}
+}
+
+// remove drops a client and closes its done channel, which ends its write
+// pump or stream. It must only be called on the Run loop.
+func (m *Manager) remove(client *Client) {
+	if _, ok := m.Clients[client]; !ok {
+		return
+	}
+	delete(m.Clients, client)
+	close(client.done)
+	m.clientCount.Store(int64(len(m.Clients)))
+}
+
+func (m *Manager) sendToAll(msg []byte) {
 	for client := range m.Clients {
 		if !client.Authenticated {
 			continue
@@ -79,8 +255,7 @@ func (m *Manager) broadcastStats() {
 		select {
 		case client.Send <- msg:
 		default:
-			close(client.Send)
-			delete(m.Clients, client)
+			m.remove(client)
 		}
 	}
 }
@@ -90,11 +265,14 @@ func (m *Manager) checkExpiry() {
 	for client := range m.Clients {
 		timeLeft := client.Expiry.Sub(now)
 
+		// Subscribers have no connection, closing done ends the stream.
 		if timeLeft <= 0 {
-			client.Conn.WriteControl(websocket.CloseMessage,
-				websocket.FormatCloseMessage(4004, "Token expired"),
-				time.Now().Add(time.Second))
-			m.Unregister <- client
+			if client.Conn != nil {
+				client.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(4004, "Token expired"),
+					time.Now().Add(time.Second))
+			}
+			m.remove(client)
 			continue
 		}
 
@@ -104,7 +282,10 @@ func (m *Manager) checkExpiry() {
 				"args":  []interface{}{fmt.Sprintf("[%s]: Your Session will expire", time.Now().Format("15:04:05"))},
 			}
 			data, _ := json.Marshal(evt)
-			client.Send <- data
+			select {
+			case client.Send <- data:
+			default:
+			}
 		}
 	}
 }
@@ -147,38 +328,266 @@ func (c *Client) ReadPump() {
 				return
 			}
 			c.Authenticated = true
+			c.Claims = claims
 		}
 
 		if !c.Authenticated {
+			c.sendError(msg.Event, models.ErrUnauthorized, "not authenticated")
 			continue
 		}
 
-		if strings.HasPrefix(msg.Event, "audio-") && len(msg.Args) > 0 {
-			playerID := msg.Args[0]
-			action := strings.TrimPrefix(msg.Event, "audio-")
-			c.Manager.Media.Control(playerID, action)
-			c.Manager.broadcastStats()
-		}
-
-		if msg.Event == "media" && len(msg.Args) > 0 {
-			switch msg.Args[0] {
-			case "play_pause":
-				c.Manager.Media.PlayPause()
-			case "next":
-				c.Manager.Media.Next()
-			case "previous":
-				c.Manager.Media.Previous()
-			case "set_position":
-				if len(msg.Args) > 1 {
-					var pos int64
-					if _, err := fmt.Sscanf(msg.Args[1], "%d", &pos); err == nil {
-						c.Manager.Media.SetPosition(pos)
-					}
-				}
-			}
-			c.Manager.broadcastStats()
+		c.handleMessage(msg.Event, msg.Args)
+	}
+}
+
+// handleMessage runs one event of an authenticated client. Actions go
+// through Execute, the rest are queries answered to this client only. A
+// failed event is answered with an error event.
+func (c *Client) handleMessage(event string, args []string) {
+	err := c.Manager.Execute(c.Claims, event, args)
+	if err == nil {
+		return
+	}
+	if err != ErrUnknownCommand {
+		c.sendError(event, ErrorCode(err), err.Error())
+		return
+	}
+
+	switch event {
+	case "auth":
+	case "screenshot":
+		if c.Claims == nil || !c.Claims.HasScope(auth.ScopeScreenshot) {
+			c.sendError(event, models.ErrForbidden, "missing scope "+auth.ScopeScreenshot)
+			return
+		}
+		go c.sendScreenshot(args)
+	case "wifi-scan":
+		go c.sendWifiNetworks()
+	case "apps":
+		c.sendEvent("apps", system.GetApplications())
+	case "windows":
+		windows, err := system.GetWindows()
+		if err != nil {
+			c.sendError(event, models.ErrNotImplemented, err.Error())
+			return
+		}
+		c.sendEvent("windows", windows)
+	case "commands":
+		c.sendEvent("commands", system.ListCommands(c.canRunCommand))
+	case "run-command":
+		if len(args) == 0 {
+			c.sendError(event, models.ErrInvalidArguments, ErrMissingArgs.Error())
+			return
+		}
+		if config.Current.ReadOnly {
+			c.sendError(event, models.ErrReadOnly, ErrReadOnly.Error())
+			return
+		}
+		macro, ok := config.Current.Commands[args[0]]
+		if !ok {
+			c.sendError(event, models.ErrNotFound, "unknown command "+args[0])
+			return
+		}
+		if !c.canRunCommand(macro) {
+			c.sendError(event, models.ErrForbidden, ErrForbidden.Error())
+			return
 		}
+		go c.runCommand(args[0], args[1:])
+	case "history":
+		if c.Manager.History == nil {
+			c.sendError(event, models.ErrUnavailable, "history is disabled")
+			return
+		}
+		if len(args) == 0 {
+			c.sendError(event, models.ErrInvalidArguments, ErrMissingArgs.Error())
+			return
+		}
+		c.sendHistory(args)
+	case "alerts":
+		c.sendEvent("alerts", c.Manager.Alerts.List())
+	case "sound-devices":
+		devices, err := system.GetAudioDevices()
+		if err != nil {
+			c.sendError(event, models.ErrInternal, err.Error())
+			return
+		}
+		c.sendEvent("sound-devices", devices)
+	default:
+		c.sendError(event, models.ErrUnknownEvent, "unknown event "+event)
+	}
+}
+
+// sendWifiNetworks waits for a fresh scan, which takes a few seconds.
+func (c *Client) sendWifiNetworks() {
+	networks, err := c.Manager.Network.Scan()
+	if err != nil {
+		c.sendError("wifi-scan", models.ErrUnavailable, err.Error())
+		return
+	}
+	c.sendEvent("wifi-networks", networks)
+}
+
+func (c *Client) sendScreenshot(args []string) {
+	req := models.ScreenshotRequest{Format: "jpeg"}
+	if len(args) > 0 {
+		req.Monitor = args[0]
+	}
+	if len(args) > 1 {
+		fmt.Sscanf(args[1], "%d", &req.Width)
+	}
+	if len(args) > 2 && args[2] != "" {
+		req.Format = args[2]
+	}
+
+	path, mime, err := system.CaptureScreenshot(req)
+	if err != nil {
+		c.sendError("screenshot", models.ErrInternal, err.Error())
+		return
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.sendError("screenshot", models.ErrInternal, err.Error())
+		return
+	}
+	c.sendEvent("screenshot", models.Screenshot{
+		Mime: mime,
+		Data: base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// runCommand streams the output of a macro to this client only. Every line
+// and the exit status carry the run id from command-started, so a client
+// can follow several runs at once. Output waits for room in Send rather
+// than being dropped, and the process is killed when the client goes away.
+func (c *Client) runCommand(name string, args []string) {
+	id := uuid.New().String()
+	c.sendEvent("command-started", models.CommandRun{ID: id, Name: name})
+
+	result := system.RunCommand(id, name, args, c.done, func(stream, line string) {
+		c.streamEvent("command-output", models.CommandOutput{ID: id, Stream: stream, Line: line})
+	})
+	c.streamEvent("command-exit", result)
+}
+
+func (c *Client) sendHistory(args []string) {
+	var from, to, step int64
+	if len(args) > 1 {
+		fmt.Sscanf(args[1], "%d", &from)
+	}
+	if len(args) > 2 {
+		fmt.Sscanf(args[2], "%d", &to)
+	}
+	if len(args) > 3 {
+		fmt.Sscanf(args[3], "%d", &step)
+	}
+
+	result, err := c.Manager.History.Query(args[0], from, to, step)
+	if err != nil {
+		c.sendError("history", models.ErrInvalidArguments, err.Error())
+		return
 	}
+	c.sendEvent("history", result)
+}
+
+// sendEvent answers this client, the reply is dropped when Send is full.
+func (c *Client) sendEvent(event string, payload interface{}) {
+	msg, ok := eventMessage(event, payload)
+	if !ok {
+		return
+	}
+	select {
+	case c.Send <- msg:
+	case <-c.done:
+	default:
+	}
+}
+
+// streamEvent waits for room in Send, until the client is removed.
+func (c *Client) streamEvent(event string, payload interface{}) {
+	msg, ok := eventMessage(event, payload)
+	if !ok {
+		return
+	}
+	select {
+	case c.Send <- msg:
+	case <-c.done:
+	}
+}
+
+func eventMessage(event string, payload interface{}) ([]byte, bool) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false
+	}
+	msg, err := json.Marshal(models.StatsEvent{
+		Event: event,
+		Args:  []string{string(data)},
+	})
+	return msg, err == nil
+}
+
+// sendError answers a failed event with the error event. The event name is
+// in the details, so a client can match the error to its request.
+func (c *Client) sendError(event, code, message string) {
+	c.sendEvent("error", models.APIError{
+		Code:    code,
+		Message: message,
+		Details: map[string]interface{}{"event": event},
+	})
+}
+
+// NewLocalClient returns an authenticated client for transports running in
+// the process, like MQTT. It is not registered with the manager, so Send
+// only receives the replies to its own events.
+func (m *Manager) NewLocalClient(claims *auth.Claims) *Client {
+	return &Client{
+		Manager:       m,
+		Send:          make(chan []byte, 64),
+		Authenticated: true,
+		Claims:        claims,
+		done:          make(chan struct{}),
+	}
+}
+
+// Subscribe registers a local client that receives every broadcast like a
+// websocket client, until its token expires or it is passed to Unregister.
+func (m *Manager) Subscribe(claims *auth.Claims) *Client {
+	client := m.NewLocalClient(claims)
+	client.Send = make(chan []byte, 256)
+	client.Expiry = time.Now().Add(20 * time.Minute)
+	if claims.ExpiresAt != nil {
+		client.Expiry = claims.ExpiresAt.Time
+	}
+	m.Register <- client
+	return client
+}
+
+// Done is closed once the client was removed from the manager.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Handle runs an event as if the client had sent it over the socket.
+func (c *Client) Handle(event string, args []string) {
+	c.handleMessage(event, args)
+}
+
+// canRunCommand checks the macro's scopes, admin when none are configured.
+func (c *Client) canRunCommand(macro config.CommandConfig) bool {
+	if c.Claims == nil {
+		return false
+	}
+	if len(macro.Scopes) == 0 {
+		return c.Claims.HasScope(auth.ScopeAdmin)
+	}
+	for _, scope := range macro.Scopes {
+		if c.Claims.HasScope(scope) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Client) WritePump() {
@@ -187,12 +596,11 @@ func (c *Client) WritePump() {
 	}()
 	for {
 		select {
-		case message, ok := <-c.Send:
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case message := <-c.Send:
 			c.Conn.WriteMessage(websocket.TextMessage, message)
+		case <-c.done:
+			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 }
@@ -209,6 +617,7 @@ func ServeWS(manager *Manager, c *gin.Context) {
 		Send:          make(chan []byte, 256),
 		Expiry:        time.Now().Add(20 * time.Minute),
 		Authenticated: false,
+		done:          make(chan struct{}),
 	}
 
 	client.Manager.Register <- client